@@ -0,0 +1,78 @@
+package mypool
+
+// ConnState 连接在连接池中的状态
+type ConnState int
+
+const (
+	// ConnCreated 刚新建, 还没有放入空闲缓冲或借出
+	ConnCreated ConnState = iota
+	// ConnIdle 在空闲缓冲中
+	ConnIdle
+	// ConnInUse 已借出
+	ConnInUse
+	// ConnClosed 已关闭
+	ConnClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnCreated:
+		return "created"
+	case ConnIdle:
+		return "idle"
+	case ConnInUse:
+		return "in-use"
+	case ConnClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Auditor 接收每条连接的状态变化, 用于审计. connID 由工厂的 Identifier 给出
+type Auditor interface {
+	OnTransition(connID string, from, to ConnState)
+}
+
+// auditEvent 一次待投递的状态变化
+type auditEvent struct {
+	factory  ConnectionFactory
+	conn     interface{}
+	from, to ConnState
+}
+
+// transitionLocked 把连接的状态改为 to, 配置了 Auditor 时记下这次变化, 由 deliverAudit 在锁外按顺序投递.
+// 状态不变或不是本连接池创建的连接不记录. 调用方需持有 c.mu
+func (c *channelPool) transitionLocked(wrapConn *idleConn, to ConnState) {
+	from := wrapConn.state
+	if from == to || wrapConn.t.IsZero() {
+		return
+	}
+	wrapConn.state = to
+	if c.auditor == nil {
+		return
+	}
+	c.auditQueue = append(c.auditQueue, auditEvent{factory: c.factoryOfLocked(wrapConn), conn: wrapConn.conn, from: from, to: to})
+	if !c.auditing {
+		c.auditing = true
+		go c.deliverAudit()
+	}
+}
+
+// deliverAudit 依次把排队的状态变化交给 Auditor, 队列空了就退出, 下次有变化时再启动.
+// 同一时刻只有一个在运行, 回调按发生顺序串行执行, 可以访问连接池
+func (c *channelPool) deliverAudit() {
+	for {
+		c.mu.Lock()
+		events := c.auditQueue
+		c.auditQueue = nil
+		if len(events) == 0 {
+			c.auditing = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+		for _, e := range events {
+			c.auditor.OnTransition(connID(e.factory, e.conn), e.from, e.to)
+		}
+	}
+}
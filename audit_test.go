@@ -0,0 +1,54 @@
+package mypool
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// recordingAuditor 按顺序记录每次状态变化
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (a *recordingAuditor) OnTransition(connID string, from, to ConnState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, fmt.Sprintf("%s %s->%s", connID, from, to))
+}
+
+func (a *recordingAuditor) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return strings.Join(a.events, ", ")
+}
+
+func TestAuditorSeesEveryTransition(t *testing.T) {
+	a := &recordingAuditor{}
+	f := identFactory{testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, Factory: f, Auditor: a})
+	for i := 0; i < 2; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			_ = p.Put(conn)
+		} else {
+			_ = p.Close(conn)
+		}
+	}
+	want := "mock-1 created->idle, mock-1 idle->in-use, mock-1 in-use->idle, mock-1 idle->in-use, mock-1 in-use->closed"
+	waitFor(t, "audit trail", func() bool { return a.String() == want })
+}
+
+func TestAuditorRequiresIdentifier(t *testing.T) {
+	_, err := NewChannelPool(&PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: testutil.NewMockFactory(), Auditor: &recordingAuditor{}})
+	if err == nil {
+		t.Fatal("NewChannelPool accepted an Auditor without an Identifier factory")
+	}
+}
@@ -0,0 +1,86 @@
+package mypool
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultAutoScaleInterval AutoScaleInterval 为 0 时控制器的调整间隔
+const defaultAutoScaleInterval = time.Second
+
+// autoScaleBounds 返回 AutoScale 的上下界, 为 0 的边界取 MaxCap
+func (poolConfig *PoolConfig) autoScaleBounds() (minCap, maxCap int) {
+	minCap, maxCap = poolConfig.AutoScaleMinCap, poolConfig.AutoScaleMaxCap
+	if minCap == 0 {
+		minCap = poolConfig.MaxCap
+	}
+	if maxCap == 0 {
+		maxCap = poolConfig.MaxCap
+	}
+	return minCap, maxCap
+}
+
+// checkAutoScale 校验 AutoScale 配置: MaxIdle <= AutoScaleMinCap <= MaxCap <= AutoScaleMaxCap
+func (poolConfig *PoolConfig) checkAutoScale() error {
+	if !poolConfig.AutoScale {
+		return nil
+	}
+	minCap, maxCap := poolConfig.autoScaleBounds()
+	if !(poolConfig.MaxIdle <= minCap && minCap <= poolConfig.MaxCap && poolConfig.MaxCap <= maxCap) || poolConfig.AutoScaleInterval < 0 {
+		return errors.New("invalid auto scale settings")
+	}
+	return nil
+}
+
+// startScaler 启动 AutoScale 控制器, Release 时退出
+func (c *channelPool) startScaler(poolConfig *PoolConfig) {
+	minCap, maxCap := poolConfig.autoScaleBounds()
+	interval := poolConfig.AutoScaleInterval
+	if interval == 0 {
+		interval = defaultAutoScaleInterval
+	}
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.scalerDone = done
+	lastWaits := c.waitCount
+	c.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lastWaits = c.scale(minCap, maxCap, lastWaits)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// scale 按上一轮以来新增的等待数调整 baseCap, BoostCap 的临时名额不受影响, 返回本轮的累计等待数
+func (c *channelPool) scale(minCap, maxCap int, lastWaits int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waits := c.waitCount - lastWaits
+	switch {
+	case c.draining: //排空后上限由 DrainTo 决定
+	case waits > 0 && c.baseCap < maxCap:
+		grow := int(min(waits, int64(maxCap-c.baseCap)))
+		c.baseCap += grow
+		c.maxActive = c.capLocked()
+		// 新增的名额先分给正在等待的 Get, 让它们重新尝试新建
+		for i := 0; i < grow; i++ {
+			req := c.popWaiterLocked()
+			if req == nil {
+				break
+			}
+			req <- connReq{}
+		}
+		c.notifyReadyLocked()
+	case waits == 0 && len(c.conns) > 0 && c.baseCap > minCap:
+		c.baseCap-- //已经打开的连接不受影响, 关闭后不再补到原来的数量
+		c.maxActive = c.capLocked()
+	}
+	return c.waitCount
+}
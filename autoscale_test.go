@@ -0,0 +1,58 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoScaleGrowsToMaxAndShrinksBack(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{
+		MaxIdle:           1,
+		MaxCap:            1,
+		WaitTimeout:       5 * time.Second,
+		AutoScale:         true,
+		AutoScaleMaxCap:   4,
+		AutoScaleInterval: 10 * time.Millisecond,
+	})
+	//6 个调用方同时取连接且一直不放回, 持续产生等待
+	got := make(chan interface{}, 6)
+	for i := 0; i < 6; i++ {
+		go func() {
+			if conn, err := p.Get(); err == nil {
+				got <- conn
+			}
+		}()
+	}
+	var held []interface{}
+	for len(held) < 4 {
+		select {
+		case conn := <-got:
+			held = append(held, conn)
+		case <-time.After(time.Second):
+			t.Fatalf("only %d Gets served, MaxActive = %d", len(held), p.Stats().MaxActive)
+		}
+	}
+	time.Sleep(50 * time.Millisecond) //再过几轮也不能超过上界
+	if stats := p.Stats(); stats.MaxActive != 4 || stats.OpenConns != 4 || stats.Waiters != 2 {
+		t.Fatalf("MaxActive = %d, OpenConns = %d, Waiters = %d; want 4, 4, 2", stats.MaxActive, stats.OpenConns, stats.Waiters)
+	}
+
+	p.FailWaiters(nil)
+	for _, conn := range held {
+		_ = p.Put(conn)
+	}
+	waitFor(t, "MaxActive to shrink back to the lower bound", func() bool { return p.Stats().MaxActive == 1 })
+}
+
+func TestAutoScaleRejectsBadBounds(t *testing.T) {
+	for _, poolConfig := range []*PoolConfig{
+		{MaxIdle: 2, MaxCap: 3, AutoScale: true, AutoScaleMinCap: 1},
+		{MaxIdle: 1, MaxCap: 3, AutoScale: true, AutoScaleMaxCap: 2},
+		{MaxIdle: 1, MaxCap: 3, AutoScale: true, AutoScaleMinCap: 4, AutoScaleMaxCap: 5},
+	} {
+		poolConfig.Factory = nopFactory{}
+		if _, err := NewChannelPool(poolConfig); err == nil {
+			t.Errorf("NewChannelPool accepted %+v", poolConfig)
+		}
+	}
+}
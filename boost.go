@@ -0,0 +1,63 @@
+package mypool
+
+import (
+	"errors"
+	"time"
+)
+
+// BoostCap 临时把连接数上限提高 extra, d 之后恢复; 恢复时总数仍超出上限的部分由空闲连接先关闭,
+// 借出的连接不强制关闭, 放回后按上限处理. 多次提升互相叠加, 各自到期时只撤回自己的那部分.
+// 排空中(DrainTo 之后)不能提升
+func (c *channelPool) BoostCap(extra int, d time.Duration) error {
+	if extra <= 0 || d <= 0 {
+		return errors.New("invalid boost settings")
+	}
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if c.draining {
+		c.mu.Unlock()
+		return errors.New("pool is draining")
+	}
+	c.boosted += extra
+	c.maxActive = c.capLocked()
+	// 新增的名额先分给正在等待的 Get, 让它们重新尝试新建
+	for i := 0; i < extra; i++ {
+		req := c.popWaiterLocked()
+		if req == nil {
+			break
+		}
+		req <- connReq{}
+	}
+	c.notifyReadyLocked()
+	c.mu.Unlock()
+
+	c.afterFunc(d, func() { c.revertBoost(extra) })
+	return nil
+}
+
+// revertBoost 撤回一次 BoostCap 提高的 extra 个名额, 关闭超出上限的空闲连接.
+// 上限按当前的 baseCap 重新计算, 期间 AutoScale 的调整和 DrainTo 设定的上限都会保留
+func (c *channelPool) revertBoost(extra int) {
+	c.mu.Lock()
+	c.boosted -= extra
+	c.maxActive = c.capLocked()
+	excess := c.openingConns - c.maxActive
+	excessIdle := c.filterIdleLocked(func(*idleConn) bool {
+		excess--
+		return excess < 0
+	})
+	c.mu.Unlock()
+	c.retire(excessIdle)
+}
+
+// capLocked 当前的连接数上限: baseCap 加上还没到期的提升, 排空中不超过 DrainTo 设定的值. 调用方需持有 c.mu
+func (c *channelPool) capLocked() int {
+	limit := c.baseCap + c.boosted
+	if c.draining {
+		limit = min(limit, c.drainCap)
+	}
+	return limit
+}
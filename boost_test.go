@@ -0,0 +1,137 @@
+package mypool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 代替 time.AfterFunc, Advance 时执行到期的回调
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Duration
+	pending []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Duration
+	f  func()
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, fakeTimer{at: c.now + d, f: f})
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now += d
+	var due []func()
+	kept := c.pending[:0]
+	for _, timer := range c.pending {
+		if timer.at <= c.now {
+			due = append(due, timer.f)
+		} else {
+			kept = append(kept, timer)
+		}
+	}
+	c.pending = kept
+	c.mu.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
+
+func TestBoostCapReverts(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2})
+	clock := &fakeClock{}
+	p.afterFunc = clock.AfterFunc
+
+	if err := p.BoostCap(2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.BoostCap(1, 2*time.Minute); err != nil { //与上一次叠加
+		t.Fatal(err)
+	}
+	if got := p.MaxActive(); got != 5 {
+		t.Fatalf("MaxActive = %d during overlapping boosts, want 5", got)
+	}
+	var conns []interface{}
+	for i := 0; i < 5; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get %d during boost: %v", i, err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns[:4] {
+		_ = p.Put(conn) //2 条放入空闲缓冲, 2 条因缓冲已满关闭, 1 条仍借出
+	}
+
+	clock.Advance(time.Minute) //第一次提升到期, 剩下 3 条没有超出
+	if got := p.MaxActive(); got != 3 {
+		t.Fatalf("MaxActive = %d after the first boost expired, want 3", got)
+	}
+	if open := p.Stats().OpenConns; open != 3 || f.Closed() != 2 {
+		t.Fatalf("OpenConns = %d, closed %d; want 3 and 2", open, f.Closed())
+	}
+
+	clock.Advance(time.Minute)
+	if got := p.MaxActive(); got != 2 {
+		t.Fatalf("MaxActive = %d after all boosts expired, want MaxCap 2", got)
+	}
+	if p.Stats().OpenConns != 2 || p.Len() != 1 || f.Closed() != 3 {
+		t.Fatalf("OpenConns = %d, idle = %d, closed %d; want the excess idle connection closed",
+			p.Stats().OpenConns, p.Len(), f.Closed())
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err == nil {
+		t.Fatal("Get beyond the reverted cap succeeded")
+	}
+}
+
+func TestBoostCapRevertKeepsDrainCap(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 10})
+	clock := &fakeClock{}
+	p.afterFunc = clock.AfterFunc
+
+	if err := p.BoostCap(10, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DrainTo(5, 0); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute) //提升到期时上限已被 DrainTo 降到 5, 不能再减
+	if got := p.MaxActive(); got != 5 {
+		t.Fatalf("MaxActive = %d after the boost expired during a drain, want 5", got)
+	}
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get after revert: %v", err)
+	}
+	_ = p.Put(conn)
+}
+
+func TestBoostCapRevertKeepsAutoScaleGrowth(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2, AutoScale: true, AutoScaleMaxCap: 4, AutoScaleInterval: time.Hour})
+	clock := &fakeClock{}
+	p.afterFunc = clock.AfterFunc
+
+	if err := p.BoostCap(2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.RLock()
+	lastWaits := p.waitCount - 1 //模拟上一轮以来有一次等待
+	p.mu.RUnlock()
+	p.scale(2, 4, lastWaits)
+	if got := p.MaxActive(); got != 5 {
+		t.Fatalf("MaxActive = %d after AutoScale grew during the boost, want 5", got)
+	}
+	clock.Advance(time.Minute)
+	if got := p.MaxActive(); got != 3 {
+		t.Fatalf("MaxActive = %d after the boost expired, want the AutoScale cap 3", got)
+	}
+}
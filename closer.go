@@ -0,0 +1,76 @@
+package mypool
+
+// closeQueueSize CloseWorker 关闭队列的容量
+const closeQueueSize = 1024
+
+// closeJob 关闭队列中的一项: 同一工厂的一批连接, 或者单条连接
+type closeJob struct {
+	factory ConnectionFactory
+	conns   []interface{}
+	batch   bool // 为 true 时按 closeConns 批量关闭, 否则 conns 只有一条, 用 Close 关闭
+}
+
+// run 执行关闭, 返回第一个错误
+func (job closeJob) run() error {
+	if job.batch {
+		return closeConns(job.factory, job.conns)
+	}
+	return job.factory.Close(job.conns[0])
+}
+
+// startCloseWorker 创建容量为 size 的关闭队列并启动关闭协程, Release 关闭队列后协程处理完剩下的再退出
+func (c *channelPool) startCloseWorker(size int) {
+	c.closeQueue = make(chan closeJob, size)
+	c.closeWorkerDone = make(chan struct{})
+	go func() {
+		defer close(c.closeWorkerDone)
+		for job := range c.closeQueue {
+			_ = job.run()
+		}
+	}()
+}
+
+// closeFactoryConn 用 factory 关闭一条连接, 见 enqueueClose
+func (c *channelPool) closeFactoryConn(factory ConnectionFactory, conn interface{}) error {
+	return c.enqueueClose(closeJob{factory: factory, conns: []interface{}{conn}})
+}
+
+// closeFactoryBatch 用 factory 批量关闭 conns, 见 enqueueClose
+func (c *channelPool) closeFactoryBatch(factory ConnectionFactory, conns []interface{}) error {
+	return c.enqueueClose(closeJob{factory: factory, conns: conns, batch: true})
+}
+
+// enqueueClose 开启 CloseWorker 时把 job 放进关闭队列后立即返回 nil, 否则(或 Release 之后)直接执行并返回错误.
+// 队列已满时不等待关闭协程, 同样直接执行, 调用方只会被这一次关闭拖慢
+func (c *channelPool) enqueueClose(job closeJob) error {
+	if c.closeQueue != nil {
+		c.closeMu.Lock()
+		if !c.closeStopped {
+			select {
+			case c.closeQueue <- job:
+				c.closeMu.Unlock()
+				return nil
+			default: //关闭协程跟不上
+			}
+		}
+		c.closeMu.Unlock()
+	}
+	return job.run()
+}
+
+// stopCloseWorker 关闭关闭队列并等关闭协程处理完, 未开启 CloseWorker 或已经停止时直接返回
+func (c *channelPool) stopCloseWorker() {
+	if c.closeQueue == nil {
+		return
+	}
+	c.closeMu.Lock()
+	if c.closeStopped {
+		c.closeMu.Unlock()
+		<-c.closeWorkerDone
+		return
+	}
+	c.closeStopped = true
+	close(c.closeQueue)
+	c.closeMu.Unlock()
+	<-c.closeWorkerDone
+}
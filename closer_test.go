@@ -0,0 +1,81 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestCloseWorkerKeepsCloseOffHotPath(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 3, CloseWorker: true})
+	f.SetCloseDelay(50 * time.Millisecond)
+	a, _ := p.Get()
+	b, _ := p.Get()
+	c, _ := p.Get()
+
+	start := time.Now()
+	_ = p.Put(a)   //放入空闲缓冲
+	_ = p.Put(b)   //空闲缓冲已满, 关闭排进队列
+	_ = p.Close(c) //同样排进队列
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("Put/Close/Get took %v, blocked on the slow Close", elapsed)
+	}
+	if f.Closed() != 0 {
+		t.Fatalf("closed %d synchronously, want the closes queued", f.Closed())
+	}
+
+	p.Release() //等队列清空
+	if f.Closed() != 2 {
+		t.Fatalf("closed %d after Release, want the 2 queued closes done", f.Closed())
+	}
+}
+
+// stuckCloseFactory 关闭 ID 为 stuckID 的连接时卡到 release 被关闭, 模拟卡住的关闭协程
+type stuckCloseFactory struct {
+	*testutil.MockFactory
+	stuckID int
+	release chan struct{}
+}
+
+func (f *stuckCloseFactory) Close(conn interface{}) error {
+	if conn.(*testutil.MockConn).ID == f.stuckID {
+		<-f.release
+	}
+	return f.MockFactory.Close(conn)
+}
+
+func TestCloseWorkerFullQueueClosesInline(t *testing.T) {
+	f := &stuckCloseFactory{MockFactory: testutil.NewMockFactory(), stuckID: 1, release: make(chan struct{})}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 3, Factory: f})
+	p.startCloseWorker(1)
+	a, _ := p.Get()
+	b, _ := p.Get()
+	c, _ := p.Get()
+
+	_ = p.Close(a) //关闭协程卡在 a 上
+	waitFor(t, "worker to pick up the first close", func() bool { return len(p.closeQueue) == 0 })
+	_ = p.Close(b) //占满队列
+	done := make(chan struct{})
+	go func() {
+		_ = p.Close(c) //队列已满, 直接关闭
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on a full close queue")
+	}
+	if f.Closed() != 1 {
+		t.Fatalf("closed %d, want only c closed inline while the worker is stuck", f.Closed())
+	}
+
+	close(f.release)
+	p.Release()
+	if f.Closed() != 3 {
+		t.Fatalf("closed %d after Release, want the queue drained", f.Closed())
+	}
+}
@@ -0,0 +1,46 @@
+package mypool
+
+import "time"
+
+// startCompactor 启动压缩协程, 每隔 interval 检查一次, Release 时退出
+func (c *channelPool) startCompactor(interval time.Duration) {
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.compactDone = done
+	lastWaits := c.waitCount
+	c.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lastWaits = c.compact(lastWaits, interval)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// compact 上一轮以来没有 Get 进入等待(突发已过), 且最早放回的空闲连接已闲置满 quiet 时关闭它,
+// 直到空闲连接数降到 InitialCap(当前的空闲上限更小时取空闲上限). 每轮只关一条, 逐步收缩,
+// 避免突发刚过又要重新拨号. 返回本轮的累计等待数
+func (c *channelPool) compact(lastWaits int64, quiet time.Duration) int64 {
+	c.mu.Lock()
+	waits := c.waitCount
+	var excess []*idleConn
+	if waits == lastWaits && len(c.conns) > min(c.initialCap, c.maxIdle) {
+		first := true
+		excess = c.filterIdleLocked(func(wrapConn *idleConn) bool {
+			if !first {
+				return true
+			}
+			first = false
+			return time.Since(wrapConn.lastUsed) < quiet //最早放回的连接最近还在用, 说明还有流量
+		})
+	}
+	c.mu.Unlock()
+	c.retire(excess)
+	return waits
+}
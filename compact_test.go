@@ -0,0 +1,80 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompactAfterBurst(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 4, MaxCap: 4, CompactInterval: 5 * time.Millisecond})
+	// 突发: 4 条连接同时借出, 放回后都留在空闲缓冲
+	var burst []interface{}
+	for i := 0; i < 4; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		burst = append(burst, conn)
+	}
+	for _, conn := range burst {
+		_ = p.Put(conn)
+	}
+	// 平静下来后逐步收缩回 InitialCap
+	waitFor(t, "idle to compact to InitialCap", func() bool { return p.Len() == 1 })
+	time.Sleep(30 * time.Millisecond)
+	if p.Len() != 1 || f.Closed() != 3 {
+		t.Fatalf("idle=%d closed=%d, want compaction to stop at InitialCap 1", p.Len(), f.Closed())
+	}
+}
+
+func TestCompactAfterLazyShrink(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 4, MaxCap: 4, LazyShrink: true, CompactInterval: 5 * time.Millisecond})
+	// 突发: 4 条连接同时借出, 放回后都留在空闲缓冲
+	var burst []interface{}
+	for i := 0; i < 4; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		burst = append(burst, conn)
+	}
+	for _, conn := range burst {
+		_ = p.Put(conn)
+	}
+	// 平静下来后把上限调回 1(低于 InitialCap), 多出的连接由压缩关闭
+	if err := p.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "idle to compact to MaxIdle", func() bool { return p.Len() == 1 })
+	if f.Closed() != 3 {
+		t.Fatalf("closed=%d, want 3", f.Closed())
+	}
+}
+
+func TestCompactSkipsWhileWaiting(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, LazyShrink: true})
+	if err := p.Resize(0); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.waitCount++ //上一轮有 Get 进入等待, 突发还没过去
+	p.mu.Unlock()
+	if waits := p.compact(0, 0); waits != 1 || p.Len() != 2 {
+		t.Fatalf("compact during a burst: waits=%d idle=%d, want 1 and 2", waits, p.Len())
+	}
+	p.compact(1, 0)
+	if p.Len() != 1 {
+		t.Fatalf("idle=%d after a quiet round, want one connection closed", p.Len())
+	}
+}
+
+func TestCompactKeepsRecentlyUsed(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2})
+	a, _ := p.Get()
+	b, _ := p.Get()
+	_ = p.Put(a)
+	_ = p.Put(b)
+	if p.compact(0, time.Minute); p.Len() != 2 {
+		t.Fatalf("idle=%d, want connections used within the quiet period kept", p.Len())
+	}
+}
@@ -0,0 +1,83 @@
+package mypool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// poolConfigJSON 配置文件格式, 除时长外的字段与 PoolConfig 同名, 时长写成 "30s" 这样的字符串
+type poolConfigJSON struct {
+	*PoolConfig
+	IdleTimeout   string
+	HedgeDelay    string
+	ValidationTTL string
+	UseTimeout    string
+	ReapInterval  string
+	CloseLinger   string
+
+	MaxConnLifetime       string
+	MaxConnLifetimeJitter string
+	MinDialInterval       string
+	SlowGetThreshold      string
+	ReaperPingTimeout     string
+	AutoScaleInterval     string
+	DrainGrace            string
+	PutDelay              string
+	CompactInterval       string
+	PingFailureWindow     string
+	PingAfterIdle         string
+	PutWait               string
+	DeadBackendWindow     string
+}
+
+// LoadConfig 从 JSON 读取连接池配置. Factory 与各回调不在文件中, 需在代码里设置
+func LoadConfig(r io.Reader) (*PoolConfig, error) {
+	poolConfig := &PoolConfig{}
+	raw := poolConfigJSON{PoolConfig: poolConfig}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid pool config: %s", err)
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"IdleTimeout", raw.IdleTimeout, &poolConfig.IdleTimeout},
+		{"HedgeDelay", raw.HedgeDelay, &poolConfig.HedgeDelay},
+		{"ValidationTTL", raw.ValidationTTL, &poolConfig.ValidationTTL},
+		{"UseTimeout", raw.UseTimeout, &poolConfig.UseTimeout},
+		{"ReapInterval", raw.ReapInterval, &poolConfig.ReapInterval},
+		{"CloseLinger", raw.CloseLinger, &poolConfig.CloseLinger},
+		{"MaxConnLifetime", raw.MaxConnLifetime, &poolConfig.MaxConnLifetime},
+		{"MaxConnLifetimeJitter", raw.MaxConnLifetimeJitter, &poolConfig.MaxConnLifetimeJitter},
+		{"MinDialInterval", raw.MinDialInterval, &poolConfig.MinDialInterval},
+		{"SlowGetThreshold", raw.SlowGetThreshold, &poolConfig.SlowGetThreshold},
+		{"ReaperPingTimeout", raw.ReaperPingTimeout, &poolConfig.ReaperPingTimeout},
+		{"AutoScaleInterval", raw.AutoScaleInterval, &poolConfig.AutoScaleInterval},
+		{"DrainGrace", raw.DrainGrace, &poolConfig.DrainGrace},
+		{"PutDelay", raw.PutDelay, &poolConfig.PutDelay},
+		{"CompactInterval", raw.CompactInterval, &poolConfig.CompactInterval},
+		{"PingFailureWindow", raw.PingFailureWindow, &poolConfig.PingFailureWindow},
+		{"PingAfterIdle", raw.PingAfterIdle, &poolConfig.PingAfterIdle},
+		{"PutWait", raw.PutWait, &poolConfig.PutWait},
+		{"DeadBackendWindow", raw.DeadBackendWindow, &poolConfig.DeadBackendWindow},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool config %s: %s", d.name, err)
+		}
+		*d.dst = v
+	}
+
+	if err := poolConfig.checkCapacity(); err != nil {
+		return nil, err
+	}
+	return poolConfig, nil
+}
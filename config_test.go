@@ -0,0 +1,31 @@
+package mypool
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	poolConfig, err := LoadConfig(strings.NewReader(`{"InitialCap":1,"MaxCap":5,"MaxIdle":3,"IdleTimeout":"30s","HedgeDelay":"5ms","IdleFromLastUse":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poolConfig.IdleTimeout != 30*time.Second || poolConfig.HedgeDelay != 5*time.Millisecond {
+		t.Fatalf("durations = %s, %s", poolConfig.IdleTimeout, poolConfig.HedgeDelay)
+	}
+	if poolConfig.MaxCap != 5 || !poolConfig.IdleFromLastUse {
+		t.Fatalf("plain fields not decoded: %+v", poolConfig)
+	}
+
+	bad := []string{
+		`{"InitialCap":4,"MaxCap":1,"MaxIdle":3}`,
+		`{"IdleTimeout":"abc"}`,
+		`{"MaxCap":`,
+	}
+	for _, in := range bad {
+		if _, err := LoadConfig(strings.NewReader(in)); err == nil {
+			t.Errorf("LoadConfig(%s) succeeded", in)
+		}
+	}
+}
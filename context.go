@@ -0,0 +1,17 @@
+package mypool
+
+import "context"
+
+// connInfoKey context 中保存 ConnInfo 的 key
+type connInfoKey struct{}
+
+// ContextWithConnInfo 返回携带 info 的 ctx, 供下游的中间件(日志、链路追踪)知道当前用的是哪条连接
+func ContextWithConnInfo(ctx context.Context, info ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// ConnInfoFromContext 取出 ContextWithConnInfo 放入的 ConnInfo, 没有时第二个返回值为 false
+func ConnInfoFromContext(ctx context.Context) (ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(ConnInfo)
+	return info, ok
+}
@@ -0,0 +1,130 @@
+package mypool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestConnInfoContextRoundTrip(t *testing.T) {
+	if _, ok := ConnInfoFromContext(context.Background()); ok {
+		t.Fatal("empty context reported a ConnInfo")
+	}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	_, info, err := p.GetWithInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ContextWithConnInfo(context.Background(), info))
+	defer cancel()
+	got, ok := ConnInfoFromContext(ctx)
+	if !ok || got != info {
+		t.Fatalf("ConnInfoFromContext = %+v, %v; want %+v", got, ok, info)
+	}
+}
+
+// lateFactory 的 FactoryContext 等 ctx 结束后才拨号成功, 模拟取消之后才完成的拨号. 不会结束的 ctx 立即拨号
+type lateFactory struct {
+	*testutil.MockFactory
+}
+
+func (f lateFactory) FactoryContext(ctx context.Context) (interface{}, error) {
+	if done := ctx.Done(); done != nil {
+		<-done
+	}
+	return f.MockFactory.Factory()
+}
+
+func TestGetContextClosesLateDial(t *testing.T) {
+	f := lateFactory{testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("GetContext = %v, want context.DeadlineExceeded", err)
+	}
+	if f.Created() != 1 || f.Closed() != 1 {
+		t.Fatalf("created=%d closed=%d, want the late connection closed", f.Created(), f.Closed())
+	}
+	if s := p.Stats(); s.OpenConns != 0 || s.InUse != 0 {
+		t.Fatalf("stats after cancelled dial = %+v, want the slot returned", s)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("Get after cancelled dial: %v", err)
+	}
+}
+
+func TestGetContextCancelWhileWaiting(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, WaitTimeout: 5 * time.Second})
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.GetContext(ctx)
+		done <- err
+	}()
+	waitFor(t, "GetContext to queue", func() bool { return p.Stats().Waiters == 1 })
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("GetContext = %v, want context.Canceled", err)
+	}
+	if p.Stats().Waiters != 0 {
+		t.Fatal("cancelled waiter still queued")
+	}
+	_ = p.Put(held)
+	if p.Len() != 1 {
+		t.Fatalf("idle=%d, want the returned connection pooled", p.Len())
+	}
+}
+
+// cancelFactory 的 FactoryContext 一直等到 ctx 结束, 返回 ctx 的错误, 模拟被调用方取消的拨号
+type cancelFactory struct {
+	*testutil.MockFactory
+}
+
+func (f cancelFactory) FactoryContext(ctx context.Context) (interface{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGetContextCancelNotCountedAsDialFailure(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, UnhealthyAfter: 1, Factory: cancelFactory{testutil.NewMockFactory()}})
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		_, err := p.GetContext(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("GetContext = %v, want context.DeadlineExceeded", err)
+		}
+	}
+	if !p.Healthy() {
+		t.Fatal("cancelled dials marked the pool unhealthy")
+	}
+}
+
+func TestGetContextLateDialKeepsFailureStreak(t *testing.T) {
+	f := lateFactory{testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, UnhealthyAfter: 2, Factory: f})
+	f.FailFactory(errors.New("connection refused"))
+	if _, err := p.Get(); err == nil {
+		t.Fatal("Get succeeded against a failing factory")
+	}
+	f.FailFactory(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("GetContext = %v, want context.DeadlineExceeded", err)
+	}
+	p.mu.RLock()
+	failures := p.dialFailures
+	p.mu.RUnlock()
+	if failures != 1 {
+		t.Fatalf("dialFailures = %d, want the discarded late dial not to reset the streak", failures)
+	}
+}
@@ -0,0 +1,62 @@
+package mypool
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// ByteCounter 连接可选实现, 返回并清零自上次调用以来的读写字节数. 连接放回或关闭时汇总到 Stats
+type ByteCounter interface {
+	TakeByteCounts() (read, written int64)
+}
+
+// CountingConn 统计读写字节数的 net.Conn 包装
+type CountingConn struct {
+	net.Conn
+	read, written atomic.Int64
+}
+
+// Read 读取并累计字节数
+func (c *CountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// Write 写入并累计字节数
+func (c *CountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// TakeByteCounts 返回并清零累计的读写字节数
+func (c *CountingConn) TakeByteCounts() (read, written int64) {
+	return c.read.Swap(0), c.written.Swap(0)
+}
+
+// CountBytes 可直接用作 PoolConfig.Decorate, 把工厂生成的 net.Conn 包装成 *CountingConn
+func CountBytes(conn interface{}) (interface{}, error) {
+	nc, ok := conn.(net.Conn)
+	if !ok {
+		return nil, fmt.Errorf("CountBytes: %T is not a net.Conn", conn)
+	}
+	return &CountingConn{Conn: nc}, nil
+}
+
+// collectBytes 连接实现了 ByteCounter 时把它的读写字节数累加到连接池
+func (c *channelPool) collectBytes(conn interface{}) {
+	bc, ok := conn.(ByteCounter)
+	if !ok {
+		return
+	}
+	read, written := bc.TakeByteCounts()
+	if read == 0 && written == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.bytesRead += read
+	c.bytesWritten += written
+	c.mu.Unlock()
+}
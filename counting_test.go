@@ -0,0 +1,54 @@
+package mypool
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// pipeFactory 用 net.Pipe 生成连接, 保存对端供测试读写
+type pipeFactory struct {
+	mu    sync.Mutex
+	peers []net.Conn
+}
+
+func (f *pipeFactory) Factory() (interface{}, error) {
+	conn, peer := net.Pipe()
+	f.mu.Lock()
+	f.peers = append(f.peers, peer)
+	f.mu.Unlock()
+	return conn, nil
+}
+
+func (f *pipeFactory) Close(conn interface{}) error { return conn.(net.Conn).Close() }
+func (f *pipeFactory) Ping(interface{}) error       { return nil }
+
+func TestCountBytes(t *testing.T) {
+	f := &pipeFactory{}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f, Decorate: CountBytes})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := conn.(net.Conn)
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(f.peers[0], buf)
+		_, _ = f.peers[0].Write([]byte("abc"))
+	}()
+	if _, err := nc.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(nc, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Put(conn)
+	if stats := p.Stats(); stats.BytesRead != 3 || stats.BytesWritten != 5 {
+		t.Fatalf("BytesRead = %d, BytesWritten = %d; want 3 and 5", stats.BytesRead, stats.BytesWritten)
+	}
+
+	if _, err := CountBytes(struct{}{}); err == nil {
+		t.Fatal("CountBytes accepted a non net.Conn")
+	}
+}
@@ -0,0 +1,88 @@
+package mypool
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// drainPollInterval DrainTo 检查借出连接是否已放回的间隔
+const drainPollInterval = 10 * time.Millisecond
+
+// DrainTo 把连接数上限降到 n 并关闭多出的空闲连接, 之后放回的连接在总数超过 n 时直接关闭.
+// 最多等待 grace 让借出的连接放回, 到期仍超过 n 时返回 ErrDrainTimeout, 借出的连接不会被强制关闭.
+// 排空是单向的, AutoScale 不再调整上限; 等待中的 Get 只能拿到 n 以内的连接, 其余按 WaitTimeout 超时
+func (c *channelPool) DrainTo(n int, grace time.Duration) error {
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if n < 0 {
+		c.mu.Unlock()
+		return errors.New("invalid capacity settings")
+	}
+	if !c.draining || n < c.drainCap { //排空是单向的, 再次调用不会提高上限
+		c.drainCap = n
+	}
+	c.draining = true
+	c.maxActive = c.capLocked()
+	c.checkDrainedLocked() //已经没有连接了
+	excess := c.openingConns - n
+	excessIdle := c.filterIdleLocked(func(*idleConn) bool {
+		excess--
+		return excess < 0
+	})
+	c.mu.Unlock()
+	c.retire(excessIdle)
+
+	deadline := time.Now().Add(grace)
+	for {
+		c.mu.RLock()
+		closed, open := c.conns == nil, c.openingConns
+		c.mu.RUnlock()
+		switch {
+		case closed:
+			return ErrClosed
+		case open <= n:
+			return nil
+		case !time.Now().Before(deadline):
+			return ErrDrainTimeout
+		}
+		time.Sleep(min(drainPollInterval, time.Until(deadline)))
+	}
+}
+
+// checkDrainedLocked 排空到 0 且连接全部关闭时在后台调用 OnDrainComplete, 只调用一次. 调用方需持有 c.mu
+func (c *channelPool) checkDrainedLocked() {
+	if c.onDrainComplete == nil || c.drained || !c.draining || c.maxActive > 0 || c.openingConns > 0 {
+		return
+	}
+	c.drained = true
+	go c.onDrainComplete()
+}
+
+// drainOnSignal 注册 sigs 的处理, 收到第一个信号时停止接收并执行 DrainTo(0, grace), Release 时退出
+func (c *channelPool) drainOnSignal(sigs []os.Signal, grace time.Duration) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.signals = ch
+	c.signalDone = done
+	c.mu.Unlock()
+	signal.Notify(ch, sigs...)
+	go func() {
+		select {
+		case sig := <-ch:
+			signal.Stop(ch) //再次收到信号时按默认行为处理
+			log.Printf("received %s, draining pool", sig)
+			if err := c.DrainTo(0, grace); err != nil {
+				log.Printf("drain on %s: %s", sig, err)
+			}
+		case <-done:
+			signal.Stop(ch)
+		}
+	}()
+}
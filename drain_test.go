@@ -0,0 +1,91 @@
+package mypool
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDrainToWaitsForReturns(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 3})
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.DrainTo(0, time.Second) }()
+	waitFor(t, "idle connection closed", func() bool { return f.Closed() == 1 })
+	select {
+	case err := <-done:
+		t.Fatalf("DrainTo returned %v with a connection still in use", err)
+	default:
+	}
+	if err := p.Put(held); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if f.Closed() != 2 || p.Len() != 0 {
+		t.Fatalf("closed=%d idle=%d after drain, want 2 and 0", f.Closed(), p.Len())
+	}
+	if _, err := p.Get(); err != ErrMaxActiveConnReached {
+		t.Fatalf("Get after drain: %v, want ErrMaxActiveConnReached", err)
+	}
+}
+
+func TestDrainToKeepsTarget(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3})
+	if err := p.DrainTo(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if f.Closed() != 2 || p.Len() != 1 {
+		t.Fatalf("closed=%d idle=%d, want 2 and 1", f.Closed(), p.Len())
+	}
+}
+
+func TestDrainToGraceElapsed(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DrainTo(0, 20*time.Millisecond); err != ErrDrainTimeout {
+		t.Fatalf("DrainTo = %v, want ErrDrainTimeout", err)
+	}
+}
+
+func TestDrainOnSignal(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2,
+		DrainOnSignal: []os.Signal{syscall.SIGTERM}, DrainGrace: time.Second})
+	p.signals <- syscall.SIGTERM //模拟收到信号, 不真的发给测试进程
+	waitFor(t, "drain to start", func() bool {
+		s := p.Stats()
+		return s.MaxActive == 0 && s.IdleConns == 0
+	})
+	waitFor(t, "idle connections closed", func() bool { return f.Closed() == 2 })
+}
+
+func TestOnDrainCompleteFiresOnce(t *testing.T) {
+	var fired atomic.Int32
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, OnDrainComplete: func() { fired.Add(1) }})
+	conn, _ := p.Get()
+	if err := p.DrainTo(0, 10*time.Millisecond); err != ErrDrainTimeout {
+		t.Fatalf("DrainTo = %v, want ErrDrainTimeout while a connection is in use", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if fired.Load() != 0 {
+		t.Fatal("OnDrainComplete fired while a connection was still in use")
+	}
+
+	_ = p.Put(conn) //最后一条连接关闭, 排空完成
+	waitFor(t, "OnDrainComplete", func() bool { return fired.Load() == 1 })
+	if err := p.DrainTo(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if fired.Load() != 1 || f.Closed() != 2 {
+		t.Fatalf("OnDrainComplete fired %d times, closed %d; want once and 2", fired.Load(), f.Closed())
+	}
+}
@@ -0,0 +1,137 @@
+package mypool
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// FailoverPool 按顺序组合多个连接池(如主、备地域), Get 从当前可用的连接池取连接, 失败时依次尝试后面的.
+// 切到备用后每隔 reprobe 让一次 Get 重新从主连接池开始尝试, 主连接池恢复后自动切回
+type FailoverPool struct {
+	mu        sync.Mutex
+	pools     []Pool
+	current   int                  // 最近一次成功提供连接的连接池下标
+	reprobe   time.Duration        // 切走后重新尝试主连接池的间隔
+	lastProbe time.Time            // 最近一次切走或重新尝试主连接池的时刻
+	owners    map[interface{}]Pool // 借出的连接及其来源, 释放后保留, 以便放回的连接交给来源连接池关闭
+	released  bool                 // 已调用 Release, Get 不再提供连接
+}
+
+// NewFailoverPool 组合 pools, 排在前面的优先使用. 各连接池的释放由 FailoverPool.Release 负责
+func NewFailoverPool(reprobe time.Duration, pools ...Pool) (*FailoverPool, error) {
+	if len(pools) == 0 {
+		return nil, errors.New("failover pool needs at least one pool")
+	}
+	return &FailoverPool{
+		pools:   append([]Pool(nil), pools...),
+		reprobe: reprobe,
+		owners:  make(map[interface{}]Pool),
+	}, nil
+}
+
+// Get 从当前连接池开始依次尝试, 都失败时返回最后一个错误. 到了重新尝试的时刻从主连接池开始
+func (f *FailoverPool) Get() (interface{}, error) {
+	f.mu.Lock()
+	if f.released {
+		f.mu.Unlock()
+		return nil, ErrClosed
+	}
+	start := f.current
+	if start > 0 && time.Since(f.lastProbe) >= f.reprobe {
+		start = 0
+		f.lastProbe = time.Now()
+	}
+	f.mu.Unlock()
+
+	var lastErr error
+	for i := 0; i < len(f.pools); i++ {
+		idx := (start + i) % len(f.pools)
+		pool := f.pools[idx]
+		conn, err := pool.Get()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		f.mu.Lock()
+		if f.released { //取连接期间被释放
+			f.mu.Unlock()
+			_ = pool.Put(conn)
+			return nil, ErrClosed
+		}
+		if idx != f.current {
+			if f.current == 0 { //从主连接池切走, 从现在开始计算重新尝试的间隔
+				f.lastProbe = time.Now()
+			}
+			f.current = idx
+		}
+		f.owners[conn] = pool
+		f.mu.Unlock()
+		return conn, nil
+	}
+	return nil, lastErr
+}
+
+// take 取出借出连接的来源连接池, 不是本组合借出的返回 ErrConnNotFound
+func (f *FailoverPool) take(conn interface{}) (Pool, error) {
+	if conn == nil {
+		return nil, errors.New("connection is nil. rejecting")
+	}
+	if !hashable(conn) {
+		return nil, ErrUnhashableConn
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pool, ok := f.owners[conn]
+	if !ok {
+		return nil, ErrConnNotFound
+	}
+	delete(f.owners, conn)
+	return pool, nil
+}
+
+// Put 把连接放回它的来源连接池. 释放后放回的连接由来源连接池关闭
+func (f *FailoverPool) Put(conn interface{}) error {
+	pool, err := f.take(conn)
+	if err != nil {
+		return err
+	}
+	return pool.Put(conn)
+}
+
+// Close 由来源连接池关闭连接
+func (f *FailoverPool) Close(conn interface{}) error {
+	pool, err := f.take(conn)
+	if err != nil {
+		return err
+	}
+	return pool.Close(conn)
+}
+
+// Release 释放所有连接池. 还没放回的连接仍然记着来源, 之后的 Put 和 Close 交给来源连接池关闭
+func (f *FailoverPool) Release() {
+	f.mu.Lock()
+	f.released = true
+	f.mu.Unlock()
+	for _, pool := range f.pools {
+		pool.Release()
+	}
+}
+
+// Len 所有连接池的空闲连接数之和
+func (f *FailoverPool) Len() int {
+	n := 0
+	for _, pool := range f.pools {
+		n += pool.Len()
+	}
+	return n
+}
+
+// Current 当前提供连接的连接池下标, 0 表示主连接池
+func (f *FailoverPool) Current() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current
+}
+
+var _ Pool = (*FailoverPool)(nil)
@@ -0,0 +1,104 @@
+package mypool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailoverPoolUsesSecondary(t *testing.T) {
+	primary, pf := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	secondary, sf := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	pf.FailFactory(errors.New("region down"))
+	f, err := NewFailoverPool(time.Hour, primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := f.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Current() != 1 || sf.Created() != 1 {
+		t.Fatalf("current=%d secondary created=%d, want the secondary to serve", f.Current(), sf.Created())
+	}
+	if err := f.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.Len() != 1 || primary.Len() != 0 {
+		t.Fatalf("conn returned to the wrong pool: primary=%d secondary=%d", primary.Len(), secondary.Len())
+	}
+	if err := f.Put(conn); err != ErrConnNotFound {
+		t.Fatalf("second Put = %v, want ErrConnNotFound", err)
+	}
+}
+
+func TestFailoverPoolReprobesPrimary(t *testing.T) {
+	primary, pf := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	secondary, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	pf.FailFactory(errors.New("region down"))
+	f, err := NewFailoverPool(20*time.Millisecond, primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := f.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Put(conn)
+	pf.FailFactory(nil)
+
+	// 重新尝试的间隔未到, 仍然使用备用
+	conn, _ = f.Get()
+	_ = f.Put(conn)
+	if f.Current() != 1 || pf.Created() != 0 {
+		t.Fatalf("primary probed before reprobe interval: current=%d created=%d", f.Current(), pf.Created())
+	}
+	time.Sleep(30 * time.Millisecond)
+	conn, err = f.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Current() != 0 || pf.Created() != 1 {
+		t.Fatalf("current=%d primary created=%d, want failback to the primary", f.Current(), pf.Created())
+	}
+	_ = f.Put(conn)
+	if primary.Len() != 1 {
+		t.Fatalf("primary idle = %d, want 1", primary.Len())
+	}
+}
+
+func TestFailoverPoolAllFail(t *testing.T) {
+	primary, pf := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	secondary, sf := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	pf.FailFactory(errors.New("primary down"))
+	last := errors.New("secondary down")
+	sf.FailFactory(last)
+	f, _ := NewFailoverPool(time.Hour, primary, secondary)
+	if _, err := f.Get(); err != last {
+		t.Fatalf("Get = %v, want the last pool's error", err)
+	}
+	f.Release()
+	if _, err := f.Get(); err != ErrClosed {
+		t.Fatalf("Get after Release = %v, want ErrClosed", err)
+	}
+}
+
+func TestFailoverPoolPutAfterReleaseClosesConn(t *testing.T) {
+	primary, pf := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	f, err := NewFailoverPool(time.Hour, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := f.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Release()
+	if _, err := f.Get(); err != ErrClosed {
+		t.Fatalf("Get after Release = %v, want ErrClosed", err)
+	}
+	_ = f.Put(conn) //来源连接池已释放, 由它关闭连接
+	if pf.Closed() != 1 {
+		t.Fatalf("closed %d, want the checked-out conn closed by its pool", pf.Closed())
+	}
+}
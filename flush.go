@@ -0,0 +1,99 @@
+package mypool
+
+// Flush 更换所有连接而不降低容量: 对每条空闲连接先新建一条顶替, 再关闭旧的;
+// 已借出的连接标记下来, 放回时关闭并新建. 新建失败的旧连接保留, 返回第一个错误
+func (c *channelPool) Flush() error {
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	for _, wrapConn := range c.active {
+		wrapConn.stale = true
+	}
+	snapshot := c.idleSnapshotLocked()
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, old := range snapshot {
+		if err := c.replaceIdle(old); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Recycle 先建后拆地替换 RecyclePredicate 选中的空闲连接, 其余连接和借出的连接不受影响.
+// 返回替换的条数; 新建失败的旧连接保留, 返回第一个错误
+func (c *channelPool) Recycle() (int, error) {
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil {
+		c.mu.Unlock()
+		return 0, ErrClosed
+	}
+	snapshot := c.idleSnapshotLocked()
+	c.mu.Unlock()
+
+	//RecyclePredicate 在锁外调用, 可以访问连接池
+	var matched []*idleConn
+	if c.recyclePredicate != nil {
+		for _, wrapConn := range snapshot {
+			if c.recyclePredicate(wrapConn.conn) {
+				matched = append(matched, wrapConn)
+			}
+		}
+	}
+
+	replaced := 0
+	var firstErr error
+	for _, old := range matched {
+		if err := c.replaceIdle(old); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		replaced++
+	}
+	return replaced, firstErr
+}
+
+// replaceIdle 先建后拆: 新建一条连接(临时允许超出 maxActive 一条), 在空闲缓冲里用它换下 old 再关闭 old.
+// old 已被借走时给它打上 stale 标记, 新连接按 Put 的流程放入
+func (c *channelPool) replaceIdle(old *idleConn) error {
+	c.mu.Lock()
+	factory, gen := c.factory, c.factoryGen
+	if c.conns == nil || factory == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.openingConns++
+	c.mu.Unlock()
+
+	c.waitDialTurn()
+	conn, err := c.create(factory)
+	if err != nil {
+		c.mu.Lock()
+		c.releaseSlotLocked()
+		c.mu.Unlock()
+		return err
+	}
+	fresh := c.newIdleConn(conn, factory, gen)
+	fresh.size = c.sizeOf(fresh)
+
+	c.mu.Lock()
+	removed := c.filterIdleLocked(func(wrapConn *idleConn) bool {
+		return wrapConn != old
+	})
+	if len(removed) == 0 { //旧连接被借走了, 放回时再处理
+		old.stale = true
+		c.mu.Unlock()
+		return c.putIdleConn(fresh)
+	}
+	c.conns <- fresh //刚取出 old, 一定有空位
+	c.idleCount.Add(1)
+	c.idleBytes += fresh.size
+	c.transitionLocked(fresh, ConnIdle)
+	c.mu.Unlock()
+	return c.closeIdleConn(old)
+}
@@ -0,0 +1,149 @@
+package mypool
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestFlush(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 4, MaxCap: 4})
+	inUse, _ := p.Get()
+	idleBefore := make(map[interface{}]bool)
+	p.mu.Lock()
+	for _, wrapConn := range p.idleSnapshotLocked() {
+		idleBefore[wrapConn.conn] = true
+	}
+	p.mu.Unlock()
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 2 || p.Stats().OpenConns != 3 {
+		t.Fatalf("after Flush: Len = %d, OpenConns = %d; want 2 and 3", p.Len(), p.Stats().OpenConns)
+	}
+	if f.Created() != 5 || f.Closed() != 2 {
+		t.Fatalf("created %d, closed %d; want 5 and 2", f.Created(), f.Closed())
+	}
+	p.mu.Lock()
+	for _, wrapConn := range p.idleSnapshotLocked() {
+		if idleBefore[wrapConn.conn] {
+			t.Error("an idle connection survived Flush")
+		}
+	}
+	p.mu.Unlock()
+
+	//Flush 时借出的连接放回时关闭, 并在后台新建顶替
+	_ = p.Put(inUse)
+	waitFor(t, "stale connection replacement", func() bool { return p.Len() == 3 })
+	if f.Created() != 6 || f.Closed() != 3 || p.Stats().OpenConns != 3 {
+		t.Fatalf("created %d, closed %d, OpenConns = %d", f.Created(), f.Closed(), p.Stats().OpenConns)
+	}
+}
+
+func TestRecycleMatchingOnly(t *testing.T) {
+	//ID 为奇数的连接连在正在重启的节点上
+	odd := func(conn interface{}) bool { return conn.(*testutil.MockConn).ID%2 == 1 }
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, RecyclePredicate: odd})
+	inUse, _ := p.Get()
+	wantReplaced := 2
+	if odd(inUse) {
+		wantReplaced = 1 //借出的那条不受影响
+	}
+
+	n, err := p.Recycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != wantReplaced || f.Closed() != wantReplaced || p.Stats().OpenConns != 4 {
+		t.Fatalf("Recycle = %d, closed %d, OpenConns = %d; want %d replaced and 4 open",
+			n, f.Closed(), p.Stats().OpenConns, wantReplaced)
+	}
+	p.mu.Lock()
+	idle := make(map[int]bool)
+	for _, wrapConn := range p.idleSnapshotLocked() {
+		idle[wrapConn.conn.(*testutil.MockConn).ID] = true
+	}
+	p.mu.Unlock()
+	for id := 1; id <= 4; id++ {
+		if id == inUse.(*testutil.MockConn).ID {
+			continue
+		}
+		if want := id%2 == 0; idle[id] != want {
+			t.Errorf("connection %d idle = %v after Recycle, want %v", id, idle[id], want)
+		}
+	}
+
+	if err := p.Put(inUse); err != nil {
+		t.Fatal(err)
+	}
+	if f.Closed() != wantReplaced {
+		t.Fatal("Recycle affected the borrowed connection")
+	}
+}
+
+func TestRecyclePredicateRunsOutsideLock(t *testing.T) {
+	var p *channelPool
+	pred := func(conn interface{}) bool {
+		return p.Len() > 0 //访问连接池, 在锁内调用会死锁
+	}
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, RecyclePredicate: pred})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if n, err := p.Recycle(); n != 2 || err != nil {
+			t.Errorf("Recycle = %d, %v; want both idle connections replaced", n, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Recycle deadlocked calling the predicate under the pool lock")
+	}
+	if f.Closed() != 2 {
+		t.Fatalf("closed %d, want the 2 recycled connections closed", f.Closed())
+	}
+}
+
+// shutdownFactory ID 不超过 lastOld 的连接属于正在关闭的旧后端, Ping 返回包装过的 ErrBackendShuttingDown
+type shutdownFactory struct {
+	*testutil.MockFactory
+	lastOld atomic.Int32
+}
+
+func (f *shutdownFactory) Ping(conn interface{}) error {
+	if conn.(*testutil.MockConn).ID <= int(f.lastOld.Load()) {
+		return fmt.Errorf("goaway: %w", ErrBackendShuttingDown)
+	}
+	return f.MockFactory.Ping(conn)
+}
+
+func TestBackendShutdownRecyclesConnections(t *testing.T) {
+	f := &shutdownFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 3, Factory: f})
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.lastOld.Store(2) //后端开始关闭, 已有的两条连接都属于它
+	if err := p.Ping(held); !errors.Is(err, ErrBackendShuttingDown) {
+		t.Fatalf("Ping = %v, want ErrBackendShuttingDown", err)
+	}
+	// 空闲的旧连接先建后拆地换掉, 借出的那条放回时换掉
+	waitFor(t, "idle connection recycled", func() bool { return f.Closed() == 1 && f.Created() == 3 })
+	if err := p.Put(held); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "held connection recycled", func() bool { return f.Closed() == 2 && p.Len() == 2 })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, wrapConn := range p.idleSnapshotLocked() {
+		if id := wrapConn.conn.(*testutil.MockConn).ID; id <= 2 {
+			t.Fatalf("connection %d from the old backend is still pooled", id)
+		}
+	}
+}
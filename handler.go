@@ -0,0 +1,96 @@
+package mypool
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// debugPage 调试页面的内容, 时长都写成 "1.5s" 这样的字符串, 与配置文件的格式一致
+type debugPage struct {
+	Stats  Stats
+	Config debugConfig
+	Idle   []debugIdle
+}
+
+type debugConfig struct {
+	MaxActive   int
+	MaxIdle     int
+	InitialCap  int
+	IdleTimeout string
+	WaitTimeout string
+}
+
+type debugIdle struct {
+	Age  string // 创建至今的时长
+	Idle string // 最后一次放回至今的时长
+	Uses int
+}
+
+var debugTemplate = template.Must(template.New("pool").Parse(`<!DOCTYPE html>
+<html><head><title>mypool</title></head><body>
+<h2>stats</h2>
+<table>
+<tr><td>open</td><td>{{.Stats.OpenConns}}</td></tr>
+<tr><td>idle</td><td>{{.Stats.IdleConns}}</td></tr>
+<tr><td>in use</td><td>{{.Stats.InUse}}</td></tr>
+<tr><td>waiters</td><td>{{.Stats.Waiters}}</td></tr>
+<tr><td>wait count</td><td>{{.Stats.WaitCount}}</td></tr>
+<tr><td>put discarded full</td><td>{{.Stats.PutDiscardedFull}}</td></tr>
+<tr><td>avg conn lifetime</td><td>{{.Stats.AvgConnLifetime}}</td></tr>
+</table>
+<h2>config</h2>
+<table>
+<tr><td>max active</td><td>{{.Config.MaxActive}}</td></tr>
+<tr><td>max idle</td><td>{{.Config.MaxIdle}}</td></tr>
+<tr><td>initial cap</td><td>{{.Config.InitialCap}}</td></tr>
+<tr><td>idle timeout</td><td>{{.Config.IdleTimeout}}</td></tr>
+<tr><td>wait timeout</td><td>{{.Config.WaitTimeout}}</td></tr>
+</table>
+<h2>idle connections</h2>
+<table>
+<tr><th>#</th><th>age</th><th>idle</th><th>uses</th></tr>
+{{range $i, $c := .Idle}}<tr><td>{{$i}}</td><td>{{$c.Age}}</td><td>{{$c.Idle}}</td><td>{{$c.Uses}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// Handler 返回调试用的 HTTP 处理器, 展示 Stats、主要配置和每条空闲连接的存活时长.
+// Accept 包含 application/json 时返回 JSON, 否则返回 HTML 页面. 每次请求读取最新状态
+func (c *channelPool) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := c.debugPage()
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(page)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = debugTemplate.Execute(w, page)
+	})
+}
+
+// debugPage 汇总调试页面的内容
+func (c *channelPool) debugPage() debugPage {
+	page := debugPage{Stats: c.Stats()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page.Config = debugConfig{
+		MaxActive:   c.maxActive,
+		MaxIdle:     c.maxIdle,
+		InitialCap:  c.initialCap,
+		IdleTimeout: c.idleTimeout.String(),
+		WaitTimeout: c.waitTimeOut.String(),
+	}
+	now := time.Now()
+	for _, wrapConn := range c.idleSnapshotLocked() {
+		page.Idle = append(page.Idle, debugIdle{
+			Age:  now.Sub(wrapConn.t).Round(time.Millisecond).String(),
+			Idle: now.Sub(wrapConn.lastUsed).Round(time.Millisecond).String(),
+			Uses: wrapConn.useCount,
+		})
+	}
+	return page
+}
@@ -0,0 +1,37 @@
+package mypool
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 3, MaxCap: 4})
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/debug/pool", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var page debugPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Stats.OpenConns != 2 || page.Stats.InUse != 1 || len(page.Idle) != 1 || page.Config.MaxActive != 4 {
+		t.Fatalf("JSON page = %+v", page)
+	}
+
+	rec = httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pool", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "<tr><td>open</td><td>2</td></tr>") || !strings.Contains(body, "<tr><td>in use</td><td>1</td></tr>") {
+		t.Fatalf("HTML page missing counts:\n%s", body)
+	}
+}
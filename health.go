@@ -0,0 +1,111 @@
+package mypool
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultUnhealthyAfter UnhealthyAfter 为 0 时判定不健康所需的连续拨号失败次数
+const defaultUnhealthyAfter = 3
+
+// recordDial 记录一次新建连接的结果, 连续失败达到阈值时连接池变为不健康, 成功一次即恢复
+func (c *channelPool) recordDial(err error) {
+	c.mu.Lock()
+	if err != nil {
+		if c.dialFailures == 0 {
+			c.firstDialFail = time.Now()
+		}
+		c.dialFailures++
+	} else {
+		c.dialFailures = 0
+	}
+	c.mu.Unlock()
+	c.notifyHealth()
+}
+
+// backendDeadLocked 开启 DeadBackendWindow 时, 拨号已连续失败 UnhealthyAfter 次以上, 且从这一轮第一次失败起
+// 已超过该时长返回 true. 只靠空闲连接运行很久的连接池偶尔拨号失败一次不会触发. 调用方需持有 c.mu
+func (c *channelPool) backendDeadLocked() bool {
+	return c.deadWindow > 0 && c.dialFailures >= c.unhealthyAfter && time.Since(c.firstDialFail) >= c.deadWindow
+}
+
+// probeBackend 后端判定为不可达时在后台每隔 DeadBackendWindow 拨号一次, 拨通的连接放入连接池, 随后 Get 恢复拨号.
+// 同一时刻只有一个在运行, 连接池释放后退出
+func (c *channelPool) probeBackend() {
+	c.mu.Lock()
+	if c.probingBackend {
+		c.mu.Unlock()
+		return
+	}
+	c.probingBackend = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.probingBackend = false
+		c.mu.Unlock()
+	}()
+	for {
+		time.Sleep(c.deadWindow)
+		c.mu.RLock()
+		dead := c.conns != nil && c.backendDeadLocked()
+		c.mu.RUnlock()
+		if !dead {
+			return
+		}
+		if err := c.dialIdle(); err == nil || err == ErrClosed || err == ErrMaxActiveConnReached {
+			return
+		}
+	}
+}
+
+// Healthy 连接池能否正常提供连接: 未释放, 未降级, 且最近的拨号没有连续失败 UnhealthyAfter 次
+func (c *channelPool) Healthy() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.healthyLocked()
+}
+
+// healthyLocked Healthy 的实现. 调用方需持有 c.mu
+func (c *channelPool) healthyLocked() bool {
+	return c.conns != nil && !c.degraded && c.dialFailures < c.unhealthyAfter
+}
+
+// notifyHealth 健康状态与上次报告的不同时调用 OnHealthChange. healthMu 保证回调按状态变化的顺序执行,
+// 回调在 c.mu 之外调用, 可以访问连接池
+func (c *channelPool) notifyHealth() {
+	if c.onHealthChange == nil {
+		return
+	}
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	healthy := c.Healthy()
+	if healthy == c.reportedHealthy {
+		return
+	}
+	c.reportedHealthy = healthy
+	c.onHealthChange(healthy)
+}
+
+// SelfTest 走一遍完整的借出、检查、放回流程, 用于启动时尽早发现工厂或 Ping 的配置错误.
+// 只借用一条连接(空闲的或新建的), 检查通过后放回; Ping 失败的连接关闭, 不放回
+func (c *channelPool) SelfTest(ctx context.Context) error {
+	conn, err := c.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("self test: get: %w", err)
+	}
+	c.mu.RLock()
+	wrapConn, ok := c.active[conn]
+	c.mu.RUnlock()
+	if !ok { //借出后被并发的 Release 移走了
+		return ErrClosed
+	}
+	if err := c.pingContext(ctx, wrapConn); err != nil {
+		_ = c.Close(conn)
+		return fmt.Errorf("self test: ping: %w", err)
+	}
+	if err := c.Put(conn); err != nil {
+		return fmt.Errorf("self test: put: %w", err)
+	}
+	return nil
+}
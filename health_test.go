@@ -0,0 +1,150 @@
+package mypool
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestHealthFollowsDials(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		changes []bool
+	)
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, UnhealthyAfter: 2, OnHealthChange: func(healthy bool) {
+		mu.Lock()
+		changes = append(changes, healthy)
+		mu.Unlock()
+	}})
+	if !p.Healthy() {
+		t.Fatal("new pool is not healthy")
+	}
+	f.FailFactory(errors.New("connection refused"))
+	for i := 0; i < 2; i++ {
+		if _, err := p.Get(); err == nil {
+			t.Fatal("Get succeeded with a failing factory")
+		}
+		if want := i == 0; p.Healthy() != want {
+			t.Fatalf("after %d failures Healthy = %v, want %v", i+1, !want, want)
+		}
+	}
+	f.FailFactory(nil)
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Healthy() {
+		t.Fatal("pool did not recover after a successful dial")
+	}
+	_ = p.Put(conn)
+	p.SetDegraded(true)
+	p.SetDegraded(false)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []bool{false, true, false, true}; !reflect.DeepEqual(changes, want) {
+		t.Fatalf("health changes = %v, want %v", changes, want)
+	}
+}
+
+// dialCountFactory 统计拨号次数, 包括失败的
+type dialCountFactory struct {
+	*testutil.MockFactory
+	dials atomic.Int32
+}
+
+func (f *dialCountFactory) Factory() (interface{}, error) {
+	f.dials.Add(1)
+	return f.MockFactory.Factory()
+}
+
+func TestDeadBackendFailsFast(t *testing.T) {
+	f := &dialCountFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f, DeadBackendWindow: 100 * time.Millisecond})
+	f.FailFactory(errors.New("connection refused"))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := p.Get()
+		if errors.Is(err, ErrBackendUnreachable) {
+			break
+		}
+		if err == nil || time.Now().After(deadline) {
+			t.Fatalf("Get = %v, want repeated dial failures to trip ErrBackendUnreachable", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dials := f.dials.Load()
+	for i := 0; i < 5; i++ {
+		if _, err := p.Get(); !errors.Is(err, ErrBackendUnreachable) {
+			t.Fatalf("Get = %v while the backend is down, want ErrBackendUnreachable", err)
+		}
+	}
+	if f.dials.Load() != dials {
+		t.Fatalf("fast-failing Get dialed %d times", f.dials.Load()-dials)
+	}
+
+	// 后端恢复后, 后台探测拨通, Get 随之恢复
+	f.FailFactory(nil)
+	waitFor(t, "probe to clear fast-fail", func() bool {
+		conn, err := p.Get()
+		if err != nil {
+			return false
+		}
+		_ = p.Put(conn)
+		return true
+	})
+}
+
+func TestDeadBackendIgnoresSingleFailure(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2, DeadBackendWindow: 50 * time.Millisecond})
+	idle, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(80 * time.Millisecond) //只靠空闲连接运行, 超过了 DeadBackendWindow
+
+	f.FailFactory(errors.New("connection refused"))
+	if _, err := p.Get(); err == nil || errors.Is(err, ErrBackendUnreachable) {
+		t.Fatalf("Get = %v, want the dial error itself", err)
+	}
+	f.FailFactory(nil)
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get = %v after one transient failure, want a fresh dial", err)
+	}
+	_ = p.Put(conn)
+	_ = p.Put(idle)
+}
+
+func TestSelfTest(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2})
+	if err := p.SelfTest(context.Background()); err != nil {
+		t.Fatalf("SelfTest = %v for a healthy factory", err)
+	}
+	if p.Len() != 2 || f.Created() != 2 || f.Closed() != 0 {
+		t.Fatalf("idle=%d, created %d, closed %d; SelfTest disturbed the warm set", p.Len(), f.Created(), f.Closed())
+	}
+
+	broken := &intermittentFactory{MockFactory: testutil.NewMockFactory()}
+	broken.failing.Store(true)
+	bp, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: broken})
+	if err := bp.SelfTest(context.Background()); err == nil {
+		t.Fatal("SelfTest = nil for a factory whose Ping always fails")
+	}
+	if bp.Len() != 0 || bp.Stats().OpenConns != 0 {
+		t.Fatalf("idle=%d, OpenConns = %d; want the failed connection closed", bp.Len(), bp.Stats().OpenConns)
+	}
+
+	dead, df := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	df.FailFactory(errors.New("connection refused"))
+	if err := dead.SelfTest(context.Background()); err == nil {
+		t.Fatal("SelfTest = nil for a factory that cannot dial")
+	}
+}
@@ -0,0 +1,35 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// newTestPool 用假工厂创建连接池, 测试结束时释放
+func newTestPool(t testing.TB, poolConfig *PoolConfig) (*channelPool, *testutil.MockFactory) {
+	t.Helper()
+	f := testutil.NewMockFactory()
+	if poolConfig.Factory == nil {
+		poolConfig.Factory = f
+	}
+	p, err := NewChannelPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Release)
+	return p.(*channelPool), f
+}
+
+// waitFor 轮询直到 cond 成立, 超过 1s 判定失败
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
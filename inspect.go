@@ -0,0 +1,110 @@
+package mypool
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 空闲连接存活时长分桶
+const (
+	ageBucketUnder1m = "<1m"
+	ageBucket1to5m   = "1-5m"
+	ageBucketOver5m  = ">5m"
+)
+
+// idleSnapshotLocked 返回空闲缓冲里所有连接的快照, 连接仍留在缓冲中. 调用方需持有 c.mu
+func (c *channelPool) idleSnapshotLocked() []*idleConn {
+	var snapshot []*idleConn
+	c.filterIdleLocked(func(wrapConn *idleConn) bool {
+		snapshot = append(snapshot, wrapConn)
+		return true
+	})
+	return snapshot
+}
+
+// Owns 判断 conn 是否由该连接池管理(空闲或已借出). 搁置中(PutDelay)和预留中的连接不在其中
+func (c *channelPool) Owns(conn interface{}) bool {
+	if conn == nil || !hashable(conn) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.active[conn]; ok {
+		return true
+	}
+	for _, wrapConn := range c.idleSnapshotLocked() {
+		if wrapConn.conn == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// IdleTimeout 配置的空闲超时
+func (c *channelPool) IdleTimeout() time.Duration {
+	return c.idleTimeout
+}
+
+// MaxActive 当前的连接数上限, AutoScale 和 DrainTo 会改变它
+func (c *channelPool) MaxActive() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxActive
+}
+
+// MaxIdle 当前的空闲连接上限, Resize 会改变它
+func (c *channelPool) MaxIdle() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxIdle
+}
+
+// InitialCap 创建时配置的初始连接数
+func (c *channelPool) InitialCap() int {
+	return c.initialCap
+}
+
+// IdleAgeHistogram 按创建至今的时长给空闲连接分桶计数
+func (c *channelPool) IdleAgeHistogram() map[string]int {
+	c.mu.Lock()
+	snapshot := c.idleSnapshotLocked()
+	c.mu.Unlock()
+
+	histogram := map[string]int{ageBucketUnder1m: 0, ageBucket1to5m: 0, ageBucketOver5m: 0}
+	now := time.Now()
+	for _, wrapConn := range snapshot {
+		switch age := now.Sub(wrapConn.t); {
+		case age < time.Minute:
+			histogram[ageBucketUnder1m]++
+		case age <= 5*time.Minute:
+			histogram[ageBucket1to5m]++
+		default:
+			histogram[ageBucketOver5m]++
+		}
+	}
+	return histogram
+}
+
+// Dump 在锁内汇总连接池状态: 各项计数、配置以及每条空闲连接的存活时长和使用次数
+func (c *channelPool) Dump() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+	snapshot := c.idleSnapshotLocked()
+	fmt.Fprintf(&b, "pool: closed=%v degraded=%v open=%d idle=%d active=%d waiters=%d lingering=%d\n",
+		c.conns == nil, c.degraded, c.openingConns, len(snapshot), len(c.active), len(c.connReqs), len(c.lingering))
+	fmt.Fprintf(&b, "config: maxActive=%d maxIdle=%d idleTimeout=%s waitTimeout=%s idleFromLastUse=%v\n",
+		c.maxActive, c.maxIdle, c.idleTimeout, c.waitTimeOut, c.idleFromLastUse)
+	for i, wrapConn := range snapshot {
+		fmt.Fprintf(&b, "idle[%d]: age=%s idle=%s uses=%d",
+			i, now.Sub(wrapConn.t).Round(time.Millisecond), now.Sub(wrapConn.lastUsed).Round(time.Millisecond), wrapConn.useCount)
+		if wrapConn.lastErr != nil {
+			fmt.Fprintf(&b, " lastErr=%q", wrapConn.lastErr.Error())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package mypool
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestIdleAgeHistogram(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3})
+	p.mu.Lock()
+	snapshot := p.idleSnapshotLocked()
+	snapshot[0].t = time.Now().Add(-2 * time.Minute)
+	snapshot[1].t = time.Now().Add(-10 * time.Minute)
+	p.mu.Unlock()
+
+	histogram := p.IdleAgeHistogram()
+	if histogram[ageBucketUnder1m] != 1 || histogram[ageBucket1to5m] != 1 || histogram[ageBucketOver5m] != 1 {
+		t.Fatalf("histogram = %v", histogram)
+	}
+	if p.Len() != 3 {
+		t.Fatalf("Len = %d after IdleAgeHistogram, want 3", p.Len())
+	}
+}
+
+func TestDump(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 3, MaxCap: 4})
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	dump := p.Dump()
+	for _, want := range []string{"open=2", "idle=1", "active=1", "maxActive=4", "maxIdle=3", "idle[0]: age="} {
+		if !strings.Contains(dump, want) {
+			t.Fatalf("Dump missing %q:\n%s", want, dump)
+		}
+	}
+	if p.Len() != 1 {
+		t.Fatalf("Len = %d after Dump, want 1", p.Len())
+	}
+}
+
+func TestOwns(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 2, MaxCap: 2})
+	other, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	foreign, _ := other.Get()
+	if p.Owns(foreign) || p.Owns(nil) {
+		t.Fatal("Owns reported a connection from another pool")
+	}
+	conn, _ := p.Get()
+	if !p.Owns(conn) {
+		t.Fatal("Owns = false for a connection just acquired")
+	}
+	_ = p.Put(conn)
+	if !p.Owns(conn) {
+		t.Fatal("Owns = false for an idle connection")
+	}
+	_ = p.Evict(conn)
+	if p.Owns(conn) {
+		t.Fatal("Owns = true for an evicted connection")
+	}
+}
+
+func TestSettingsAccessors(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 3, MaxCap: 5, IdleTimeout: time.Minute})
+	if p.IdleTimeout() != time.Minute || p.MaxActive() != 5 || p.MaxIdle() != 3 || p.InitialCap() != 1 {
+		t.Fatalf("accessors = %s %d %d %d, want 1m 5 3 1", p.IdleTimeout(), p.MaxActive(), p.MaxIdle(), p.InitialCap())
+	}
+	if err := p.Resize(2); err != nil {
+		t.Fatal(err)
+	}
+	if p.MaxIdle() != 2 {
+		t.Fatalf("MaxIdle after Resize = %d, want 2", p.MaxIdle())
+	}
+}
+
+func TestLastErrRecorded(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2})
+	p.mu.Lock()
+	bad := p.idleSnapshotLocked()[0]
+	p.mu.Unlock()
+	f.Break(bad.conn.(*testutil.MockConn))
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if bad.lastErr != testutil.ErrMockPing {
+		t.Fatalf("discarded connection lastErr = %v, want %v", bad.lastErr, testutil.ErrMockPing)
+	}
+}
+
+func TestLastErrInInfoAndDump(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, ValidationTTL: time.Hour})
+	conn, _ := p.Get()
+	f.Break(conn.(*testutil.MockConn))
+	if err := p.Ping(conn); err == nil {
+		t.Fatal("Ping of a broken connection succeeded")
+	}
+	_ = p.Put(conn) //ValidationTTL 内不再检查, 连接留在连接池里
+	if dump := p.Dump(); !strings.Contains(dump, `lastErr="`+testutil.ErrMockPing.Error()+`"`) {
+		t.Fatalf("Dump missing lastErr:\n%s", dump)
+	}
+	_, info, err := p.GetWithInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.LastErr != testutil.ErrMockPing {
+		t.Fatalf("ConnInfo.LastErr = %v, want %v", info.LastErr, testutil.ErrMockPing)
+	}
+}
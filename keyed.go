@@ -0,0 +1,135 @@
+package mypool
+
+import (
+	"errors"
+	"sync"
+)
+
+// KeyedConnectionFactory 按分片 key 创建连接的工厂
+type KeyedConnectionFactory interface {
+	//为指定分片生成连接的方法
+	Factory(key string) (interface{}, error)
+	//关闭连接的方法
+	Close(interface{}) error
+	//检查连接是否有效的方法
+	Ping(interface{}) error
+}
+
+// shardFactory 把 KeyedConnectionFactory 绑定到一个分片上, 作为子连接池的工厂
+type shardFactory struct {
+	key     string
+	factory KeyedConnectionFactory
+}
+
+func (f *shardFactory) Factory() (interface{}, error) { return f.factory.Factory(f.key) }
+func (f *shardFactory) Close(conn interface{}) error  { return f.factory.Close(conn) }
+func (f *shardFactory) Ping(conn interface{}) error   { return f.factory.Ping(conn) }
+
+// KeyedPool 按分片 key 维护各自独立的子连接池, 子连接池在第一次使用时创建
+type KeyedPool struct {
+	mu      sync.Mutex
+	config  PoolConfig
+	factory KeyedConnectionFactory
+	pools   map[string]*keyedShard // nil 表示已释放
+}
+
+// keyedShard 一个分片的子连接池. 创建在锁外进行, 同一分片的其他调用方等 ready 关闭后读取结果
+type keyedShard struct {
+	ready chan struct{}
+	pool  Pool
+	err   error
+}
+
+// NewKeyedPool 创建分片连接池, 所有子连接池共用 poolConfig (其中的 Factory 被忽略)
+func NewKeyedPool(poolConfig *PoolConfig, factory KeyedConnectionFactory) (*KeyedPool, error) {
+	if err := poolConfig.checkCapacity(); err != nil {
+		return nil, err
+	}
+	if factory == nil {
+		return nil, errors.New("invalid factory interface settings")
+	}
+	return &KeyedPool{
+		config:  *poolConfig,
+		factory: factory,
+		pools:   make(map[string]*keyedShard),
+	}, nil
+}
+
+// pool 返回 key 对应的子连接池, 不存在且 create 为 true 时创建.
+// 子连接池的初始填充在锁外进行, 慢速或不可达的分片不会阻塞其他分片
+func (k *KeyedPool) pool(key string, create bool) (Pool, error) {
+	k.mu.Lock()
+	if k.pools == nil {
+		k.mu.Unlock()
+		return nil, ErrClosed
+	}
+	shard, ok := k.pools[key]
+	if ok {
+		k.mu.Unlock()
+		<-shard.ready
+		return shard.pool, shard.err
+	}
+	if !create {
+		k.mu.Unlock()
+		return nil, nil
+	}
+	shard = &keyedShard{ready: make(chan struct{})}
+	k.pools[key] = shard
+	k.mu.Unlock()
+
+	poolConfig := k.config
+	poolConfig.Factory = &shardFactory{key: key, factory: k.factory}
+	if poolConfig.ExpvarName != "" { //每个分片单独发布, 名字加上分片 key
+		poolConfig.ExpvarName += "." + key
+	}
+	shard.pool, shard.err = NewChannelPool(&poolConfig)
+	if shard.err != nil { //创建失败不缓存, 下次使用时重试
+		k.mu.Lock()
+		if k.pools != nil && k.pools[key] == shard {
+			delete(k.pools, key)
+		}
+		k.mu.Unlock()
+	}
+	close(shard.ready)
+	return shard.pool, shard.err
+}
+
+// GetKey 从 key 对应的子连接池取一个连接
+func (k *KeyedPool) GetKey(key string) (interface{}, error) {
+	p, err := k.pool(key, true)
+	if err != nil {
+		return nil, err
+	}
+	return p.Get()
+}
+
+// PutKey 把连接放回 key 对应的子连接池, 子连接池不存在时直接关闭该连接
+func (k *KeyedPool) PutKey(key string, conn interface{}) error {
+	if conn == nil {
+		return errors.New("connection is nil. rejecting")
+	}
+	p, err := k.pool(key, false)
+	if err != nil || p == nil {
+		_ = k.factory.Close(conn)
+		if err != nil {
+			return err
+		}
+		return ErrConnNotFound
+	}
+	return p.Put(conn)
+}
+
+// Release 释放所有子连接池
+func (k *KeyedPool) Release() {
+	k.mu.Lock()
+	pools := k.pools
+	k.pools = nil
+	k.mu.Unlock()
+
+	for _, shard := range pools {
+		<-shard.ready //正在创建的子连接池等创建完再释放
+		if shard.pool != nil {
+			shard.pool.Release()
+		}
+	}
+}
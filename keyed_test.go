@@ -0,0 +1,71 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// keyedMock 把 MockFactory 当作按 key 拨号的工厂, gates 中的 key 拨号时阻塞到对应 channel 关闭
+type keyedMock struct {
+	*testutil.MockFactory
+	gates map[string]chan struct{}
+}
+
+func (k *keyedMock) Factory(key string) (interface{}, error) {
+	if gate, ok := k.gates[key]; ok {
+		<-gate
+	}
+	return k.MockFactory.Factory()
+}
+
+func TestKeyedPoolExpvarPerShard(t *testing.T) {
+	k, err := NewKeyedPool(&PoolConfig{MaxIdle: 1, MaxCap: 1, ExpvarName: "keyed"}, &keyedMock{MockFactory: testutil.NewMockFactory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Release()
+	for _, key := range []string{"a", "b"} {
+		if _, err := k.GetKey(key); err != nil {
+			t.Fatalf("GetKey(%q) = %v", key, err)
+		}
+		if _, ok := expvarStats(t, "keyed."+key); !ok {
+			t.Fatalf("shard %q not published", key)
+		}
+	}
+}
+
+func TestKeyedPoolSlowShardDoesNotBlockOthers(t *testing.T) {
+	gate := make(chan struct{})
+	f := &keyedMock{MockFactory: testutil.NewMockFactory(), gates: map[string]chan struct{}{"slow": gate}}
+	k, err := NewKeyedPool(&PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Release()
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := k.GetKey("slow")
+		slowDone <- err
+	}()
+	time.Sleep(10 * time.Millisecond) //slow 分片正在初始填充
+	fastDone := make(chan error, 1)
+	go func() {
+		_, err := k.GetKey("fast")
+		fastDone <- err
+	}()
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetKey(fast) blocked behind the slow shard")
+	}
+	close(gate)
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,10 +1,16 @@
 package mypool
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +19,36 @@ var (
 	ErrClosed = errors.New("pool is closed")
 	//ErrMaxActiveConnReached 连接池超限
 	ErrMaxActiveConnReached = errors.New("MaxActiveConnReached")
+	//ErrConnNotFound 连接不属于该连接池
+	ErrConnNotFound = errors.New("connection not found in pool")
+	//ErrTenantLimit 单个租户借出的连接数超限
+	ErrTenantLimit = errors.New("tenant connection limit reached")
+	//ErrDegraded 连接池处于降级状态, 拒绝获取连接
+	ErrDegraded = errors.New("pool is degraded")
+	//ErrAlreadyClosed 连接已经被连接池关闭过, 不会再次调用工厂的 Close
+	ErrAlreadyClosed = errors.New("connection already closed")
+	//ErrDialRateLimited 距上次拨号不足 MinDialInterval, 且等不到下一次可拨号的时刻
+	ErrDialRateLimited = errors.New("dial rate limited")
+	//ErrUnhashableConn 连接的值不可比较, 无法作为 key 登记, 不能放入连接池
+	ErrUnhashableConn = errors.New("connection is not comparable and cannot be pooled")
+	//ErrBackendShuttingDown 工厂的 Ping 返回(或包装)它表示后端正在关闭, 连接池随即在后台换掉所有连接
+	ErrBackendShuttingDown = errors.New("backend is shutting down")
+	//ErrDrainTimeout DrainTo 的宽限期已过, 仍有借出的连接没有放回
+	ErrDrainTimeout = errors.New("drain grace period elapsed with connections still in use")
+	//ErrBackendUnreachable 拨号已经连续失败超过 DeadBackendWindow, 在后台探测成功之前 Get 不再拨号
+	ErrBackendUnreachable = errors.New("backend unreachable")
+	//ErrNoMatchingConn GetMatching 没有找到满足条件的空闲连接, 新建的连接也不满足
+	ErrNoMatchingConn = errors.New("no connection matches the predicate")
+)
+
+const (
+	// maxAsyncResets 同时进行的后台 ResetOnPut 个数上限
+	maxAsyncResets = 16
+	// maxDelayedPuts 同时等待 PutDelay 到期的连接数上限, 超出时立即放回
+	maxDelayedPuts = 64
+	// closedHistory 为识别重复关闭而记住的最近关闭的连接数. 这些连接在被挤出记录前不会被回收,
+	// 更早关闭的连接再次 Close/Put 时识别不出来
+	closedHistory = 1024
 )
 
 // Pool 基本方法
@@ -29,7 +65,7 @@ type Pool interface {
 	Len() int
 }
 
-// ConnectionFactory 连接工厂
+// ConnectionFactory 连接工厂. 生成的连接必须可比较(如指针), 连接池以它为 key 登记元信息
 type ConnectionFactory interface {
 	//生成连接的方法
 	Factory() (interface{}, error)
@@ -39,6 +75,48 @@ type ConnectionFactory interface {
 	Ping(interface{}) error
 }
 
+// BatchCloser 工厂可选实现, 一次请求关闭多条连接. 批量销毁连接时优先使用
+type BatchCloser interface {
+	CloseBatch(conns []interface{}) error
+}
+
+// PingContexter 工厂可选实现, 在 ctx 结束前完成检查. 回收协程按 ReaperPingTimeout 检查空闲连接时优先使用
+type PingContexter interface {
+	PingContext(ctx context.Context, conn interface{}) error
+}
+
+// ContextFactory 工厂可选实现, 拨号在 ctx 结束时放弃. GetContext 新建连接时优先使用
+type ContextFactory interface {
+	FactoryContext(ctx context.Context) (interface{}, error)
+}
+
+// Identifier 工厂可选实现, 返回连接的标识(如 "本地地址->远端地址"), 用于和后端日志对应.
+// 实现后连接池丢弃连接时记一行带标识的日志, ConnInfo.ID 也会填上
+type Identifier interface {
+	ID(conn interface{}) string
+}
+
+// SelfChecker 工厂可选实现, 由连接自身记录的状态(如协议层标记的错误)判断是否可用, 不产生网络往返.
+// 实现后 Put 先询问它, 不可用的连接直接关闭, 不再执行 ValidateOnPut 的 Ping
+type SelfChecker interface {
+	Healthy(conn interface{}) bool
+}
+
+// Sizer 工厂可选实现, 返回连接当前占用的内存字节数(如缓冲区大小), 配合 MaxIdleBytes 使用
+type Sizer interface {
+	Size(conn interface{}) int64
+}
+
+// FillOrder 初始填充的拨号方式
+type FillOrder int
+
+const (
+	// FillSequential 逐条拨号, 下标从小到大
+	FillSequential FillOrder = iota
+	// FillInterleaved 同时拨号, 各条连接的建立交错进行
+	FillInterleaved
+)
+
 // PoolConfig 连接池相关配置
 type PoolConfig struct {
 	//连接池中拥有的最小连接数
@@ -47,68 +125,673 @@ type PoolConfig struct {
 	//最大并发存活连接数
 	MaxCap int
 
-	//最大空闲连接
-	MaxIdle int //TODO:用途?
+	//最大空闲连接, 即空闲缓冲的容量. 为 0 时是不复用模式: 每次 Get 都新建连接, Put 交给等待者或直接关闭,
+	//连接池只限制同时存在的连接不超过 MaxCap, 此时 InitialCap 也必须为 0
+	MaxIdle int
 
 	// 工厂
 	Factory ConnectionFactory
 
 	//连接最大空闲时间，超过该事件则将失效
 	IdleTimeout time.Duration
+
+	//为 true 时空闲时间从最后一次放回(Put)算起, 默认从连接创建时刻算起
+	IdleFromLastUse bool
+
+	//Get 返回连接前执行的准备工作(如选库、设置超时), 出错则丢弃该连接并换一条
+	OnGet func(conn interface{}) error
+
+	//拨号超过该时长仍未返回则并行再拨一次(需有空余名额), 取先完成的那个, 为 0 时不开启
+	HedgeDelay time.Duration
+
+	//单个租户同时借出的最大连接数, 为 0 时不限制
+	MaxPerTenant int
+
+	//为 true 时初始化填充尽力而为: 拨号失败只记日志, 用成功的连接启动连接池
+	BestEffortFill bool
+
+	//距上次校验不到该时长的连接, Get 时跳过 Ping, 为 0 时每次都 Ping
+	ValidationTTL time.Duration
+	//Get 每秒最多因健康检查(Ping)不通过丢弃这么多条空闲连接, 超出后不再 Ping, 直接借出空闲连接,
+	//避免后端抖动时连接池反复关闭和拨号. 超过 IdleTimeout 或 MaxConnLifetime 的连接总是丢弃, 不受限制. 为 0 时不限制
+	MaxDiscardRate int
+	//为 true 时 Put 也检查空闲超时: 借出期间已超过 IdleTimeout 的连接直接关闭, 不再放回空闲缓冲
+	CheckIdleOnPut bool
+	//放回后空闲超过该时长的连接, Get 时必须 Ping, 即使还在 ValidationTTL 之内; Ping 失败才关闭. 为 0 时不强制
+	PingAfterIdle time.Duration
+
+	//Get 返回连接前调用, 传入 当前时刻+UseTimeout 作为截止时间(如 net.Conn 的 SetDeadline)
+	ApplyDeadline func(conn interface{}, t time.Time)
+	//连接单次使用的时长上限, 为 0 时不调用 ApplyDeadline
+	UseTimeout time.Duration
+
+	//降级状态下仍然把已有的空闲连接借出去, 只是不新建
+	ServeIdleWhenDegraded bool
+
+	//连接数达到上限时 Get 最多等待多久有连接放回, 为 0 时直接返回 ErrMaxActiveConnReached.
+	//空闲连接全部失效且拨号失败时, 若还有借出的连接, 同样在该时长内等待它们放回, 超时返回拨号错误
+	WaitTimeout time.Duration
+
+	//新连接创建成功后立即执行一次(如认证或初始化语句), 出错则关闭该连接并返回错误. 初始填充、Get 新建和后台补充
+	//等所有新建路径都会执行, 之后的 Get 复用连接时不再执行; 每次借出前的准备用 OnGet
+	OnCreate func(conn interface{}) error
+
+	//后台回收协程的执行间隔, 定期关闭空闲超时的连接, 为 0 时不启动
+	ReapInterval time.Duration
+	//回收的连接先在关闭队列里停留该时长再真正关闭, 为 0 时立即关闭
+	CloseLinger time.Duration
+
+	//Put 时先 Ping 一次, 失败的连接不放回连接池
+	ValidateOnPut bool
+	//ValidateOnPut 校验失败时调用, 返回替代连接则放入连接池, 原连接总是会被关闭
+	OnPutValidationFail func(conn interface{}) (replacement interface{}, err error)
+
+	//拨号成功后包装原始连接(如 bufio、TLS), 连接池保存和借出的都是包装后的值,
+	//工厂的 Close/Ping 收到的也是包装后的值. 出错时关闭原始连接
+	Decorate func(conn interface{}) (interface{}, error)
+
+	//为 true 时在后台填充 InitialCap 个初始连接, NewChannelPool 立即返回, 失败只记日志
+	AsyncFill bool
+
+	//初始填充的拨号方式, 默认 FillSequential 逐条拨号; FillInterleaved 同时发起所有初始拨号(AsyncFill 时不适用)
+	FillOrder FillOrder
+	//初始填充每次拨号前调用, index 为这条初始连接的下标 [0, InitialCap), 供轮询多个后端的工厂选择目标.
+	//FillSequential 时按下标顺序调用; FillInterleaved 时并发调用, 顺序不定
+	OnFillDial func(index int)
+
+	//为 true 时 NewChannelPool 先同步拨一条探测连接, 失败立即返回指明工厂的错误(不受 BestEffortFill、AsyncFill 影响).
+	//探测连接算作第一条初始连接, InitialCap 为 0 时放入空闲缓冲
+	ProbeFactory bool
+
+	//连接最长存活时间, 从创建算起, 超过后在 Get 或回收时关闭, 为 0 时不限制
+	MaxConnLifetime time.Duration
+	//每条连接的存活时间在 MaxConnLifetime 基础上随机延长 [0, 该值), 避免同一批连接同时重连
+	MaxConnLifetimeJitter time.Duration
+
+	//为 true 时回收协程每轮还会 Ping 所有空闲连接, 关闭失效的并新建连接顶替(受 MaxCap 限制)
+	ReplaceBadIdle bool
+	//回收协程检查单条空闲连接的时长上限, 超时按失效处理, 为 0 时不限制.
+	//工厂实现了 PingContexter 时通过 ctx 取消, 否则不再等待那次 Ping
+	ReaperPingTimeout time.Duration
+
+	//不为空时以该名字把 Stats 发布到 expvar 的 "mypool" 下 (/debug/vars 中的 mypool.<名字>),
+	//同一时刻名字不能重复, Release 时撤销
+	ExpvarName string
+
+	//Put 时重置连接的会话状态(如回滚事务、清空临时表), 出错则关闭该连接
+	ResetOnPut func(conn interface{}) error
+	//为 true 时 ResetOnPut 在后台执行, Put 立即返回, 重置成功后连接才放入连接池.
+	//同时进行的后台重置最多 maxAsyncResets 个, 超出时在 Put 内同步重置
+	AsyncReset bool
+
+	//两次新建连接之间的最小间隔, 为 0 时不限制. Get 未到间隔时在 WaitTimeout 内等待, 否则返回 ErrDialRateLimited;
+	//初始填充、后台顶替和 Flush 等其余拨号排队等到间隔满足. 对冲拨号只在间隔允许时才发出第二次
+	MinDialInterval time.Duration
+
+	//单次 Get(含等待和拨号)耗时超过 SlowGetThreshold 时调用 OnSlowGet, 传入实际耗时. 阈值为 0 时不开启
+	SlowGetThreshold time.Duration
+	OnSlowGet        func(d time.Duration)
+
+	//每次取连接结束时调用, 传入结果(取自空闲连接、新建或失败)和耗时, 用于接入自定义指标. 与 OnGet 不同, 只观察不干预.
+	//覆盖 Get 以及 GetTagged、GetMatching、GetOrCreate 等所有取连接的方法和 Reservation.Acquire, 每次调用报告一次,
+	//GetWithPolicy 的重试也只在最后报告一次
+	ObserveGet func(outcome GetOutcome, latency time.Duration)
+	//每次 Put 结束时调用, 传入连接是放回还是被关闭, 以及耗时. 两个回调都在锁外调用, 为 nil 时不开启
+	ObservePut func(outcome PutOutcome, latency time.Duration)
+
+	//为 true 时 Resize 调小 MaxIdle 不立即关闭多出的空闲连接, 只拒绝超出上限的 Put, 多出的连接等空闲超时后关闭
+	LazyShrink bool
+	//压缩间隔, 为 0 时不开启. 每隔该时长检查一次, 期间没有 Get 进入等待、最早放回的空闲连接已闲置满一个间隔,
+	//且空闲连接多于 InitialCap(当前的空闲上限更小时取空闲上限)时关闭一条, 让突发时拨出的连接(包括 LazyShrink
+	//保留下来的)在突发过后逐步收缩, 而不必等空闲超时
+	CompactInterval time.Duration
+
+	//为 true 时后台控制器每隔 AutoScaleInterval(为 0 时为 1s) 根据期间进入等待的 Get 数调整连接数上限:
+	//有等待时按等待数调大, 没有等待且有空闲连接时调小 1, 始终保持在 [AutoScaleMinCap, AutoScaleMaxCap] 内.
+	//MaxCap 是初始上限; 两个边界为 0 时取 MaxCap, AutoScaleMinCap 不能小于 MaxIdle
+	AutoScale         bool
+	AutoScaleMinCap   int
+	AutoScaleMaxCap   int
+	AutoScaleInterval time.Duration
+
+	//Put 的连接先搁置该时长再放入连接池, 期间不能被取到, 用于对频繁复用敏感的后端. 为 0 时立即放回.
+	//同时搁置的连接最多 maxDelayedPuts 条, 超出时立即放回; Release 时搁置中的连接立即关闭
+	PutDelay time.Duration
+	//Put 遇到空闲缓冲已满时最多等待该时长, 期间有 Get 取走连接空出位置就放入, 否则照常关闭. 为 0 或开启 EvictOldestOnPut 时不等待
+	PutWait time.Duration
+
+	//为 true 时 Get 因 Ping 失败丢弃空闲连接后, 若连接数低于 InitialCap, 立即在后台新建一条补上, 保持热连接数稳定
+	RefillOnPingFail bool
+
+	//单条连接的 Ping 失败次数超过该值后关闭, 即使之后的 Ping 又成功了, 用于淘汰时好时坏的连接. 为 0 时不限制
+	MaxPingFailures int
+	//失败次数的统计窗口: 从窗口内第一次失败起经过该时长后重新计数. 为 0 时不重置
+	PingFailureWindow time.Duration
+
+	//空闲连接占用内存的预算(字节), 需工厂实现 Sizer. Put 时放入后会超出预算的连接直接关闭, 为 0 时不限制
+	MaxIdleBytes int64
+
+	//GetTagged 找不到该标签的空闲连接时, 用它把一条其他标签(或未配置)的空闲连接改配为 newTag (如切换数据库),
+	//出错则关闭那条连接. 为 nil 时直接新建
+	Retag func(conn interface{}, newTag string) error
+
+	//Recycle 用它挑出要替换的空闲连接, 如连到正在滚动重启的节点上的连接. 在锁外调用, 可以访问连接池. 为 nil 时 Recycle 不做任何事
+	RecyclePredicate func(conn interface{}) bool
+
+	//备用连接数. 备用连接在后台拨好, 不放入空闲缓冲, 只在空闲连接用完时才交给 Get, 随后补上新的;
+	//它们计入 MaxCap. 开启 ReapInterval 时回收协程每轮检查备用连接, 失效或超过存活时间的换掉
+	StandbyCount int
+
+	//为 true 时放回连接遇到空闲缓冲已满, 关闭缓冲里最早创建的连接并放入放回的这条(若它更新), 让热连接保持年轻
+	EvictOldestOnPut bool
+
+	//大于 1 时 Get 在最早放回的这么多条空闲连接里取借出次数最少的一条, 避免少数连接被反复使用, 让各连接的磨损均衡.
+	//为 0 或 1 时按 FIFO 取最早放回的
+	BalanceWindow int
+
+	//连续多少次新建连接失败后判定连接池不健康, 为 0 时取 defaultUnhealthyAfter. 成功一次即恢复健康
+	UnhealthyAfter int
+	//拨号连续失败至少 UnhealthyAfter 次且从第一次失败起已超过该时长时, 需要新建连接的 Get 立即返回
+	//ErrBackendUnreachable, 不再拨号; 后台每隔该时长探测一次, 拨通后恢复. 为 0 时不开启
+	DeadBackendWindow time.Duration
+	//Healthy 的结果变化时调用(包括 SetDegraded 和 Release 引起的变化), 调用按变化顺序串行进行
+	OnHealthChange func(healthy bool)
+
+	//收到其中任一信号时执行 DrainTo(0, DrainGrace), 只响应第一次, 之后恢复信号的默认行为.
+	//注意 signal.Notify 会取消这些信号的默认处理(如 SIGTERM 不再结束进程), 进程需自行退出;
+	//同一信号的其他 Notify 不受影响, 会同样收到
+	DrainOnSignal []os.Signal
+	DrainGrace    time.Duration
+	//DrainTo(0, ...) 之后最后一条连接关闭时在后台调用一次, 即使 DrainTo 已因宽限期到期返回, 供关闭流程继续
+	OnDrainComplete func()
+
+	//为 true 时所有连接的关闭都放进队列, 由一个后台协程依次执行, Get/Put/Close 不会被慢速的工厂 Close 阻塞,
+	//此时 Close 不返回工厂 Close 的错误. 队列满(closeQueueSize)时不等待, 改为在调用方直接关闭; Release 等队列清空后才返回
+	CloseWorker bool
+
+	//接收每条连接的状态变化(新建、空闲、借出、关闭), 在锁外按发生顺序串行调用. 需要工厂实现 Identifier
+	Auditor Auditor
+}
+
+// checkCapacity 校验容量相关配置
+func (poolConfig *PoolConfig) checkCapacity() error {
+	if !(poolConfig.InitialCap <= poolConfig.MaxIdle && poolConfig.MaxCap >= poolConfig.MaxIdle && poolConfig.InitialCap >= 0) ||
+		poolConfig.StandbyCount < 0 || poolConfig.InitialCap+poolConfig.StandbyCount > poolConfig.MaxCap {
+		return errors.New("invalid capacity settings")
+	}
+	return nil
+}
+
+// closedEntry 关闭记录中的一项, seq 用来判断 closedKeys 里的记录是否还是这一次关闭
+type closedEntry struct {
+	conn interface{}
+	seq  uint64
 }
 
 type connReq struct {
 	idleConn *idleConn
+	err      error // FailWaiters 发给等待者的错误
 }
 
 type idleConn struct {
-	conn interface{}
-	t    time.Time //连接创建的时刻
+	conn     interface{}
+	t        time.Time //连接创建的时刻
+	lastUsed time.Time //最后一次放回连接池的时刻
+	evicted  bool      //已被 Evict 标记, 放回时直接关闭
+	tenant   string    //借出该连接的租户, 为空表示普通 Get
+	overCap  bool      //GetOrCreate 超出 maxActive 新建的连接, 放回时直接关闭
+	stale    bool      //Flush 时处于借出状态, 放回时关闭并新建一条顶替
+
+	lastValidated time.Time //最后一次确认连接有效(新建或 Ping 成功)的时刻
+	useCount      int       //被借出的次数
+
+	factory    ConnectionFactory //创建该连接的工厂, 关闭和 Ping 都用它
+	factoryGen int               //创建时工厂的版本号, 批量关闭时按它分组
+	lifetime   time.Duration     //该连接的最长存活时间(已加上随机抖动), 为 0 时不限制
+	size       int64             //放入空闲缓冲时 Sizer 给出的字节数, 计入 idleBytes
+	tag        string            //GetTagged 配置过的标签, 放回后保留, 为空表示未配置
+	lastErr    error             //最近一次检查失败(Ping 或 OnGet)的错误, 之后检查通过也保留
+
+	pingFailures     int       //统计窗口内 Ping 失败的次数
+	pingFailingSince time.Time //统计窗口内第一次 Ping 失败的时刻
+
+	state  ConnState //当前状态, 由 transitionLocked 修改
+	dialed bool      //本次借出的是新建的连接, 而不是空闲或备用连接
+}
+
+// ConnInfo 借出连接的元信息
+type ConnInfo struct {
+	CreatedAt time.Time // 连接创建的时刻
+	LastUsed  time.Time // 最后一次放回连接池的时刻, 新建的连接为创建时刻
+	UseCount  int       // 被借出的次数, 包括本次
+	ID        string    // 工厂实现了 Identifier 时为连接的标识, 否则为空
+	LastErr   error     // 最近一次检查失败的错误, 没有失败过时为 nil
+}
+
+// Age 连接创建至今的时长
+func (info ConnInfo) Age() time.Duration {
+	return time.Since(info.CreatedAt)
+}
+
+// newIdleConn 为刚创建的连接生成元信息, 并按配置确定它的存活时间
+func (c *channelPool) newIdleConn(conn interface{}, factory ConnectionFactory, gen int) *idleConn {
+	now := time.Now()
+	wrapConn := &idleConn{conn: conn, t: now, lastUsed: now, lastValidated: now, factory: factory, factoryGen: gen}
+	if c.maxConnLifetime > 0 {
+		wrapConn.lifetime = c.maxConnLifetime
+		if c.maxConnLifetimeJitter > 0 {
+			wrapConn.lifetime += time.Duration(rand.Int63n(int64(c.maxConnLifetimeJitter)))
+		}
+	}
+	return wrapConn
+}
+
+// info 生成连接的元信息. 调用方需持有 c.mu 或独占该连接
+func (wrapConn *idleConn) info() ConnInfo {
+	return ConnInfo{
+		CreatedAt: wrapConn.t,
+		LastUsed:  wrapConn.lastUsed,
+		UseCount:  wrapConn.useCount,
+		ID:        connID(wrapConn.factory, wrapConn.conn),
+		LastErr:   wrapConn.lastErr,
+	}
+}
+
+// connID 工厂实现了 Identifier 时返回连接的标识, 否则返回空串
+func connID(factory ConnectionFactory, conn interface{}) string {
+	if identifier, ok := factory.(Identifier); ok {
+		return identifier.ID(conn)
+	}
+	return ""
 }
 
 // channelPool 存放连接信息
 type channelPool struct {
 	mu                       sync.RWMutex
 	conns                    chan *idleConn // buffer channel 存储 空闲连接,buffer长度 poolConfig.MaxIdle.               连接数量 一开始为   poolConfig.InitialCap.
+	idleCount                atomic.Int64   // conns 中的连接数, 每次放入/取出后同步增减, 供 Len 无锁读取
 	factory                  ConnectionFactory
 	idleTimeout, waitTimeOut time.Duration /// 连接空闲超时和等待超时
 
-	maxActive    int // 最大连接数. 起限制作用
+	maxActive    int // 最大连接数. 起限制作用, 由 capLocked 根据下面三项算出
+	baseCap      int // 不含 BoostCap 临时名额的上限, AutoScale 调整的是它
+	boosted      int // 还没到期的 BoostCap 名额之和
+	drainCap     int // DrainTo 设定的上限, draining 时有效
 	openingConns int // 记录当前打开的连接数量. 初始化为最小连接数
 
-	//	connReqs                 []chan connReq // 连接请求缓冲区，如果无法从 conns 取到连接，则在这个缓冲区创建一个新的元素，之后连接放回去时先填充这个缓冲区  TODO:?
+	idleFromLastUse bool                      // 空闲时间是否从最后一次使用算起
+	active          map[interface{}]*idleConn // 已借出的连接及其元信息, 连接需可比较(作为 map 的 key)
+	onGet           func(conn interface{}) error
+	hedgeDelay      time.Duration
+	maxPerTenant    int
+	tenantInUse     map[string]int // 各租户当前借出的连接数
+	validationTTL   time.Duration
+	pingAfterIdle   time.Duration
+	checkIdleOnPut  bool
+
+	maxDiscardRate  int
+	discardTokens   float64   // MaxDiscardRate 令牌桶中剩余的令牌
+	discardRefilled time.Time // 上次补充令牌的时刻
+	applyDeadline   func(conn interface{}, t time.Time)
+	useTimeout      time.Duration
+
+	degraded              bool // 手动降级开关
+	serveIdleWhenDegraded bool
+	onCreate              func(conn interface{}) error
+	factoryGen            int // 工厂版本号, 每次 SetFactory 加一
+
+	closeLinger time.Duration
+	lingering   []*lingerBatch // 等待延迟关闭的连接
+	reaperDone  chan struct{}  // Release 时关闭, 通知回收协程退出
+	scalerDone  chan struct{}  // Release 时关闭, 通知 AutoScale 控制器退出
+	signalDone  chan struct{}  // Release 时关闭, 通知信号处理协程退出
+	compactDone chan struct{}  // Release 时关闭, 通知压缩协程退出
+	signals     chan os.Signal // DrainOnSignal 的接收 channel
+	draining    bool           // DrainTo 之后为 true, 超出 maxActive 的连接放回时关闭
+	drained     bool           // 已经排空到 0 并调用过 onDrainComplete
+	recycling   bool           // 后端关闭引起的 Flush 正在进行
+	waitCount   int64          // 累计进入等待的 Get 次数
+
+	validateOnPut       bool
+	onPutValidationFail func(conn interface{}) (interface{}, error)
+	decorate            func(conn interface{}) (interface{}, error)
+	ready               chan struct{} // 初始填充完成后关闭
+
+	maxConnLifetime, maxConnLifetimeJitter time.Duration
+	replaceBadIdle                         bool
+	reaperPingTimeout                      time.Duration
+	readyCh                                chan struct{} // 有 WaitReady 在等时才创建, 状态变化时关闭
+	onFillDial                             func(index int)
+
+	auditor    Auditor
+	auditQueue []auditEvent // 还没交给 auditor 的状态变化
+	auditing   bool         // 有 deliverAudit 正在投递
+
+	onDrainComplete func()
+
+	closeQueue      chan closeJob // CloseWorker 的关闭队列, 未开启时为 nil
+	closeWorkerDone chan struct{} // 关闭协程退出时关闭
+	closeMu         sync.Mutex    // 保护 closeStopped, 与向 closeQueue 发送和关闭 closeQueue 互斥
+	closeStopped    bool          // Release 已关闭 closeQueue, 之后的关闭直接执行
+
+	bytesRead, bytesWritten int64 // 从 ByteCounter 连接汇总的读写字节数
+	putDiscardedFull        int64 // 放回时空闲缓冲已满而关闭的连接数
+	maxIdleBytes            int64
+	idleBytes               int64 // 空闲缓冲里连接的字节数之和
+
+	closedLifetimeSum   time.Duration // 已关闭连接的存活时长之和
+	closedLifetimeCount int64         // 计入 closedLifetimeSum 的连接数
+
+	resetOnPut func(conn interface{}) error
+	resetSem   chan struct{} // AsyncReset 时限制同时进行的后台重置数
+
+	putDelay    time.Duration
+	delayedPuts map[*idleConn]struct{} // 正在等待 PutDelay 到期的连接, 第一次搁置时创建
+	putWait     time.Duration
+
+	putWaiters atomic.Int32  // 正在 waitIdleSpace 中等待空位的 Put 数, popIdle 据此决定是否加锁通知
+	spaceCh    chan struct{} // 有 Put 在等空位时才创建, 空闲缓冲取出连接、来了等待者或连接池释放时关闭
+
+	afterFunc func(d time.Duration, f func()) // 定时回调, 即 time.AfterFunc, 测试时可替换为假时钟
+
+	minDialInterval time.Duration
+	nextDial        time.Time // 下一次允许拨号的时刻
+
+	slowGetThreshold time.Duration
+	onSlowGet        func(d time.Duration)
+	observeGet       func(outcome GetOutcome, latency time.Duration)
+	observePut       func(outcome PutOutcome, latency time.Duration)
+
+	maxIdle          int // 空闲连接上限, 可由 Resize 调整, 不超过 conns 的容量
+	initialCap       int
+	refillOnPingFail bool
+	maxPingFailures  int
+	pingFailWindow   time.Duration
+	retag            func(conn interface{}, newTag string) error
+	recyclePredicate func(conn interface{}) bool
+	lazyShrink       bool
+	evictOldestOnPut bool
+	balanceWindow    int
+
+	standbyCount   int
+	standby        []*idleConn // 备用连接, 不在 conns 里
+	standbyPending int         // 正在拨号或检查中的备用连接数
+
+	unhealthyAfter  int
+	dialFailures    int // 连续失败的新建连接次数
+	onHealthChange  func(healthy bool)
+	healthMu        sync.Mutex // 串行执行 OnHealthChange
+	reportedHealthy bool       // 最近一次报告给 OnHealthChange 的状态, 初始为健康
+
+	deadWindow     time.Duration
+	firstDialFail  time.Time // 这一轮连续拨号失败中第一次失败的时刻, dialFailures 为 0 时无意义
+	probingBackend bool      // 有 probeBackend 正在后台探测
+
+	closedKeys map[interface{}]uint64 // 最近关闭过的连接及其关闭序号, 最多 closedHistory 条
+	closedRing []closedEntry          // 按关闭顺序排列, 超出时淘汰最早的
+	closedSeq  uint64
+
+	expvarName string // 已发布的 expvar 名字, Release 时撤销
+
+	connReqs []chan connReq // 连接请求缓冲区，如果无法从 conns 取到连接，则在这个缓冲区创建一个新的元素，之后连接放回去时先填充这个缓冲区
 }
 
 // NewChannelPool 初始化连接
 func NewChannelPool(poolConfig *PoolConfig) (Pool, error) {
 	// 校验参数
-	if !(poolConfig.InitialCap <= poolConfig.MaxIdle && poolConfig.MaxCap >= poolConfig.MaxIdle && poolConfig.InitialCap >= 0) {
-		return nil, errors.New("invalid capacity settings")
+	if err := poolConfig.checkCapacity(); err != nil {
+		return nil, err
 	}
 	if poolConfig.Factory == nil {
 		return nil, errors.New("invalid factory interface settings")
 	}
+	if err := poolConfig.checkAutoScale(); err != nil {
+		return nil, err
+	}
+	if _, ok := poolConfig.Factory.(Identifier); poolConfig.Auditor != nil && !ok {
+		return nil, errors.New("auditor requires a factory implementing Identifier")
+	}
 
 	c := &channelPool{
 		conns:        make(chan *idleConn, poolConfig.MaxIdle),
 		factory:      poolConfig.Factory,
 		idleTimeout:  poolConfig.IdleTimeout,
 		maxActive:    poolConfig.MaxCap,
+		baseCap:      poolConfig.MaxCap,
 		openingConns: poolConfig.InitialCap,
+
+		idleFromLastUse: poolConfig.IdleFromLastUse,
+		active:          make(map[interface{}]*idleConn),
+		onGet:           poolConfig.OnGet,
+		hedgeDelay:      poolConfig.HedgeDelay,
+		maxPerTenant:    poolConfig.MaxPerTenant,
+		tenantInUse:     make(map[string]int),
+		validationTTL:   poolConfig.ValidationTTL,
+		pingAfterIdle:   poolConfig.PingAfterIdle,
+		checkIdleOnPut:  poolConfig.CheckIdleOnPut,
+		maxDiscardRate:  poolConfig.MaxDiscardRate,
+		discardTokens:   float64(poolConfig.MaxDiscardRate),
+		discardRefilled: time.Now(),
+		applyDeadline:   poolConfig.ApplyDeadline,
+		useTimeout:      poolConfig.UseTimeout,
+
+		serveIdleWhenDegraded: poolConfig.ServeIdleWhenDegraded,
+		waitTimeOut:           poolConfig.WaitTimeout,
+		onCreate:              poolConfig.OnCreate,
+		closeLinger:           poolConfig.CloseLinger,
+		validateOnPut:         poolConfig.ValidateOnPut,
+		onPutValidationFail:   poolConfig.OnPutValidationFail,
+		decorate:              poolConfig.Decorate,
+		ready:                 make(chan struct{}),
+
+		maxConnLifetime:       poolConfig.MaxConnLifetime,
+		maxConnLifetimeJitter: poolConfig.MaxConnLifetimeJitter,
+		replaceBadIdle:        poolConfig.ReplaceBadIdle,
+		reaperPingTimeout:     poolConfig.ReaperPingTimeout,
+		resetOnPut:            poolConfig.ResetOnPut,
+		putDelay:              poolConfig.PutDelay,
+		putWait:               poolConfig.PutWait,
+		afterFunc:             func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+		maxIdleBytes:          poolConfig.MaxIdleBytes,
+		minDialInterval:       poolConfig.MinDialInterval,
+		slowGetThreshold:      poolConfig.SlowGetThreshold,
+		onSlowGet:             poolConfig.OnSlowGet,
+		observeGet:            poolConfig.ObserveGet,
+		observePut:            poolConfig.ObservePut,
+		maxIdle:               poolConfig.MaxIdle,
+		initialCap:            poolConfig.InitialCap,
+		refillOnPingFail:      poolConfig.RefillOnPingFail,
+		maxPingFailures:       poolConfig.MaxPingFailures,
+		pingFailWindow:        poolConfig.PingFailureWindow,
+		retag:                 poolConfig.Retag,
+		recyclePredicate:      poolConfig.RecyclePredicate,
+		standbyCount:          poolConfig.StandbyCount,
+		onFillDial:            poolConfig.OnFillDial,
+		auditor:               poolConfig.Auditor,
+		onDrainComplete:       poolConfig.OnDrainComplete,
+		lazyShrink:            poolConfig.LazyShrink,
+		evictOldestOnPut:      poolConfig.EvictOldestOnPut,
+		balanceWindow:         poolConfig.BalanceWindow,
+		onHealthChange:        poolConfig.OnHealthChange,
+		deadWindow:            poolConfig.DeadBackendWindow,
+		unhealthyAfter:        poolConfig.UnhealthyAfter,
+		reportedHealthy:       true,
+		closedKeys:            make(map[interface{}]uint64),
 	}
-	////初始化, 生成 最小连接数 个连接数量. 放在 conns里
-	for i := 0; i < poolConfig.InitialCap; i++ {
-		conn, err := c.factory.Factory()
-		if err != nil {
+	if c.unhealthyAfter <= 0 {
+		c.unhealthyAfter = defaultUnhealthyAfter
+	}
+	if poolConfig.AsyncReset && poolConfig.ResetOnPut != nil {
+		c.resetSem = make(chan struct{}, maxAsyncResets)
+	}
+	// 先占住 expvar 名字, 初始填充失败时由 Release 撤销
+	if err := c.publishExpvar(poolConfig.ExpvarName); err != nil {
+		return nil, err
+	}
+	if poolConfig.CloseWorker {
+		c.startCloseWorker(closeQueueSize)
+	}
+	filled := 0 // 已经放入的初始连接数
+	if poolConfig.ProbeFactory {
+		if err := c.probe(poolConfig.InitialCap > 0); err != nil {
 			c.Release()
-			return nil, fmt.Errorf("factory is not able to fill the pool: %s", err)
+			return nil, err
 		}
-		c.conns <- &idleConn{conn: conn, t: time.Now()}
+		if poolConfig.InitialCap > 0 {
+			filled = 1
+		}
+	}
+	if poolConfig.AsyncFill {
+		go c.fillAsync(filled, poolConfig.InitialCap)
+		c.startWorkers(poolConfig)
+		return c, nil
 	}
+	////初始化, 生成 最小连接数 个连接数量. 放在 conns里
+	if err := c.fill(filled, poolConfig.InitialCap, poolConfig.FillOrder, poolConfig.BestEffortFill); err != nil {
+		c.Release()
+		return nil, err
+	}
+	close(c.ready)
+	c.startWorkers(poolConfig)
 
 	return c, nil
 }
 
+// fill 同步拨好下标 [from, to) 的初始连接放入空闲缓冲, 名额已在构造时占好. FillInterleaved 时同时拨号.
+// bestEffort 时失败的只记日志并归还名额, 否则返回第一个错误, 已拨好的留在缓冲里由调用方 Release 关闭
+func (c *channelPool) fill(from, to int, order FillOrder, bestEffort bool) error {
+	type fillResult struct {
+		conn interface{}
+		err  error
+	}
+	results := make([]fillResult, to-from)
+	if order == FillInterleaved {
+		var wg sync.WaitGroup
+		for i := from; i < to; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				conn, err := c.fillDial(i, c.factory)
+				results[i-from] = fillResult{conn, err}
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := from; i < to; i++ {
+			conn, err := c.fillDial(i, c.factory)
+			results[i-from] = fillResult{conn, err}
+			if err != nil && !bestEffort {
+				break
+			}
+		}
+	}
+
+	var firstErr error
+	for _, r := range results {
+		switch {
+		case r.conn != nil:
+			wrapConn := c.newIdleConn(r.conn, c.factory, c.factoryGen)
+			wrapConn.size = c.sizeOf(wrapConn)
+			c.mu.Lock()
+			c.idleBytes += wrapConn.size
+			c.conns <- wrapConn
+			c.idleCount.Add(1)
+			c.transitionLocked(wrapConn, ConnIdle)
+			c.mu.Unlock()
+		case r.err != nil && bestEffort:
+			log.Printf("factory is not able to fill the pool: %s", r.err)
+			c.mu.Lock()
+			c.openingConns--
+			c.mu.Unlock()
+		case r.err != nil && firstErr == nil:
+			firstErr = fmt.Errorf("factory is not able to fill the pool: %s", r.err)
+		}
+	}
+	return firstErr
+}
+
+// fillDial 拨第 index 条初始连接, 先调用 OnFillDial 让工厂据此选择目标
+func (c *channelPool) fillDial(index int, factory ConnectionFactory) (interface{}, error) {
+	if c.onFillDial != nil {
+		c.onFillDial(index)
+	}
+	c.waitDialTurn()
+	return c.create(factory)
+}
+
+// startWorkers 按配置启动回收协程、AutoScale 控制器、压缩协程、信号处理和备用连接的填充, 前几个在 Release 时退出
+func (c *channelPool) startWorkers(poolConfig *PoolConfig) {
+	if poolConfig.ReapInterval > 0 {
+		c.startReaper(poolConfig.ReapInterval)
+	}
+	if poolConfig.AutoScale {
+		c.startScaler(poolConfig)
+	}
+	if poolConfig.CompactInterval > 0 {
+		c.startCompactor(poolConfig.CompactInterval)
+	}
+	if poolConfig.StandbyCount > 0 {
+		go c.fillStandby()
+	}
+	if len(poolConfig.DrainOnSignal) > 0 {
+		c.drainOnSignal(poolConfig.DrainOnSignal, poolConfig.DrainGrace)
+	}
+}
+
+// probe 拨一条探测连接确认工厂可用. counted 为 true 时它占用已预留的初始连接名额,
+// 否则另占一个名额; 连接放入空闲缓冲, 放不下时关闭
+func (c *channelPool) probe(counted bool) error {
+	var conn interface{}
+	var err error
+	if counted { //第一条初始连接
+		conn, err = c.fillDial(0, c.factory)
+	} else {
+		c.waitDialTurn()
+		conn, err = c.create(c.factory)
+	}
+	if err != nil {
+		return fmt.Errorf("factory %T probe failed: %s", c.factory, err)
+	}
+	if !counted {
+		c.openingConns++
+	}
+	_ = c.putIdleConn(c.newIdleConn(conn, c.factory, c.factoryGen))
+	return nil
+}
+
+// fillAsync 在后台填充 n 个初始连接, 名额已在构造时占好. 失败只记日志并归还名额, 全部完成后关闭 ready
+func (c *channelPool) fillAsync(from, to int) {
+	defer close(c.ready)
+	for i := from; i < to; i++ {
+		c.mu.RLock()
+		factory, gen := c.factory, c.factoryGen
+		c.mu.RUnlock()
+		if factory == nil { //填充过程中连接池被释放, 归还剩下的名额
+			c.mu.Lock()
+			for ; i < to; i++ {
+				c.releaseSlotLocked()
+			}
+			c.mu.Unlock()
+			return
+		}
+		conn, err := c.fillDial(i, factory)
+		if err != nil {
+			log.Printf("factory is not able to fill the pool: %s", err)
+			c.mu.Lock()
+			c.releaseSlotLocked()
+			c.mu.Unlock()
+			continue
+		}
+		// 按 Put 的流程放入: 先满足等待者, 再进空闲缓冲, 连接池已释放则关闭
+		_ = c.putIdleConn(c.newIdleConn(conn, factory, gen))
+	}
+}
+
+// Ready 返回初始填充完成后关闭的 channel. 同步填充时 NewChannelPool 返回前已关闭
+func (c *channelPool) Ready() <-chan struct{} {
+	return c.ready
+}
+
 // getConns 获取所有连接
 func (c *channelPool) getConns() chan *idleConn {
 	c.mu.Lock()
@@ -119,157 +802,1629 @@ func (c *channelPool) getConns() chan *idleConn {
 
 // Get 从pool中取一个连接
 func (c *channelPool) Get() (interface{}, error) {
+	return c.GetContext(context.Background())
+}
+
+// GetContext 与 Get 相同, 但 ctx 结束时停止等待并返回 ctx 的错误. 工厂实现了 ContextFactory 时拨号也受 ctx 控制;
+// 拨号在 ctx 结束后才返回的连接会被关闭并归还名额, 不会泄漏
+func (c *channelPool) GetContext(ctx context.Context) (interface{}, error) {
+	if c.observeGet == nil {
+		return c.getContext(ctx)
+	}
+	observed := time.Now()
+	conn, err := c.getContext(ctx)
+	c.observeGet(c.getOutcome(conn, err), time.Since(observed))
+	return conn, err
+}
+
+// getContext GetContext 的实现, 不调用 ObserveGet, 供已经在报告结果的取连接方法内部使用
+func (c *channelPool) getContext(ctx context.Context) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	start := c.slowGetStart()
+	conn, err := c.get(ctx, c.waitTimeOut)
+	c.slowGetDone(start)
+	return conn, err
+}
+
+// slowGetStart 配置了 OnSlowGet 时返回当前时刻, 否则返回零值, 与 slowGetDone 配对使用
+func (c *channelPool) slowGetStart() time.Time {
+	if c.onSlowGet == nil || c.slowGetThreshold <= 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// slowGetDone 从 start 算起超过 SlowGetThreshold 时调用 OnSlowGet
+func (c *channelPool) slowGetDone(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	if d := time.Since(start); d > c.slowGetThreshold {
+		c.onSlowGet(d)
+	}
+}
+
+// checkServing 各种取连接的入口共用的检查: 已释放返回 ErrClosed, 降级且不允许使用空闲连接时返回 ErrDegraded
+func (c *channelPool) checkServing() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch {
+	case c.conns == nil:
+		return ErrClosed
+	case c.degraded && !c.serveIdleWhenDegraded:
+		return ErrDegraded
+	}
+	return nil
+}
+
+// get Get 的实际逻辑: 先取空闲连接, 没有时新建, 到达上限时最多等待 maxWait
+func (c *channelPool) get(ctx context.Context, maxWait time.Duration) (interface{}, error) {
 	conns := c.getConns() //获取所有连接
 	if conns == nil {     //没有连接 报错
 		return nil, ErrClosed
 	}
+	if err := c.checkServing(); err != nil { //降级且不允许使用空闲连接, 直接失败
+		return nil, err
+	}
+	var deadline time.Time // 阻塞等待的截止时刻, 第一次进入等待时确定
+	// 一次 Get 最多丢弃一整个空闲缓冲那么多条连接, 之后不再消耗空闲连接, 直接走新建流程
+	discards, maxDiscards := 0, cap(conns)
+	var dialErr error // 空闲连接全部失效后拨号也失败时记下错误, 改为等待借出的连接放回
 	for {
-		select {
-		case wrapConn := <-conns:
-			if wrapConn == nil {
-				return nil, ErrClosed
-			}
-			//判断是否超时，超时则丢弃
-			timeout := c.idleTimeout //空闲时间不为0,才校验
-			if timeout > 0 {
-				if wrapConn.t.Add(timeout).Before(time.Now()) { //连接创建的时刻+空闲时间 比当前时间小,则该连接闲的时间太久了. 关闭他.
-					//丢弃并关闭该连接
-					_ = c.Close(wrapConn.conn)
+		if discards < maxDiscards {
+			if wrapConn, ok := c.popIdle(conns); ok {
+				if wrapConn == nil {
+					return nil, ErrClosed
+				}
+				if !c.validate(wrapConn) { //超时、失效或 OnGet 失败, 已丢弃, 换下一条
+					if c.getConns() == nil { //丢弃期间连接池被释放, 不再继续取
+						return nil, ErrClosed
+					}
+					discards++
 					continue
 				}
+				//不超时,也没失效. 则返回该连接.
+				return c.checkout(wrapConn), nil
 			}
-			//判断是否失效，失效则丢弃，如果用户没有设定 ping 方法，就不检查
-			if err := c.Ping(wrapConn.conn); err != nil {
-				_ = c.Close(wrapConn.conn)
+		}
+		//没有空闲连接, 没到上限就新建, 到了上限按配置等待或报错
+		c.mu.Lock()
+		if c.degraded { //降级期间不新建连接
+			c.mu.Unlock()
+			return nil, ErrDegraded
+		}
+		if wrapConn := c.promoteStandbyLocked(); wrapConn != nil { //空闲连接用完了, 先用备用连接顶上
+			c.mu.Unlock()
+			go c.fillStandby()
+			if !c.validate(wrapConn) {
 				continue
 			}
-			//不超时,也没失效. 则返回该连接.
-			return wrapConn.conn, nil
-
-		default: ////TODO: 不停的getConns, 连接都拿完啦.那可怎么办?
-			c.mu.Lock()
-			log.Printf("openConn %v %v", c.openingConns, c.maxActive)
-			if c.openingConns >= c.maxActive { ///当前的连接数已经太多
+			return c.checkout(wrapConn), nil
+		}
+		if c.openingConns >= c.maxActive || dialErr != nil { ///当前的连接数已经太多, 或者拨不通只能等放回
+			if maxWait <= 0 {
+				c.mu.Unlock()
 				return nil, ErrMaxActiveConnReached
-				// // 如果达到上限，则创建一个缓冲channel，///在缓冲区里, 等待放回去的连接.
-				// req := make(chan connReq, 1)
-				// c.connReqs = append(c.connReqs, req)
-				// c.mu.Unlock()
-				// // 判断是否有连接放回去（放回去逻辑在 put 方法内）
-				// ret, ok := <-req
-				// // 如果没有连接放回去，则不能再创建新的连接了，因为达到上限了
-				// if !ok {
-				// 	return nil, ErrMaxActiveConnReached
-				// }
-				// // 如果有连接放回去了 判断连接是否可用
-				// if timeout := c.idleTimeout; timeout > 0 {
-				// 	if ret.idleConn.t.Add(timeout).Before(time.Now()) {
-				// 		//丢弃并关闭该连接
-				// 		// 重新尝试获取连接
-				// 		_ = c.Close(ret.idleConn.conn)
-				// 		continue
-				// 	}
-				// }
-				// return ret.idleConn.conn, nil
 			}
-
-			// 到这里说明 没有空闲连接 && 连接数没有达到上限 可以创建新连接
-			if c.factory == nil {
-				c.mu.Unlock()
-				return nil, ErrClosed
+			if deadline.IsZero() {
+				deadline = time.Now().Add(maxWait)
+			}
+			wrapConn, err := c.wait(ctx, deadline)
+			if err == ErrMaxActiveConnReached && dialErr != nil { //等到超时也没有连接放回, 报告拨号失败的原因
+				return nil, dialErr
 			}
-			conn, err := c.factory.Factory()
 			if err != nil {
-				c.mu.Unlock()
 				return nil, err
 			}
-			c.openingConns++
+			// 有名额空出来了(wrapConn 为 nil)或者放回的连接不可用, 重新尝试
+			if wrapConn == nil {
+				dialErr = nil
+				continue
+			}
+			if !c.validate(wrapConn) {
+				if c.getConns() == nil {
+					return nil, ErrClosed
+				}
+				discards++
+				continue
+			}
+			return c.checkout(wrapConn), nil
+		}
+
+		// 到这里说明 没有空闲连接 && 连接数没有达到上限 可以创建新连接
+		if c.factory == nil {
+			c.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if c.backendDeadLocked() { //后端已经拨不通一段时间了, 不再拨号, 交给后台探测
+			c.mu.Unlock()
+			go c.probeBackend()
+			return nil, ErrBackendUnreachable
+		}
+		// 限制拨号频率: 预约下一个可拨号的时刻, 等不到则报错
+		dialDeadline := deadline
+		if dialDeadline.IsZero() {
+			dialDeadline = time.Now().Add(maxWait)
+		}
+		dialWait, err := c.reserveDialLocked(dialDeadline)
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		// 先占住一个名额再到锁外拨号, 慢速拨号不会阻塞其他操作
+		factory, gen := c.factory, c.factoryGen
+		c.openingConns++
+		c.mu.Unlock()
+		if dialWait > 0 && !sleepContext(ctx, dialWait) {
+			c.mu.Lock()
+			c.releaseSlotLocked()
+			c.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		conn, err := c.createContext(ctx, factory)
+		if err == nil && ctx.Err() != nil { //拨号在取消之后才完成, 连接没人要了
+			_ = c.closeIdleConn(c.newIdleConn(conn, factory, gen))
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			c.mu.Lock()
+			c.releaseSlotLocked()
+			// 空闲连接都失效了又拨不通: 允许阻塞时等借出的连接放回, 它们可能仍然可用
+			waitForPut := maxWait > 0 && discards > 0 && len(c.active) > 0
 			c.mu.Unlock()
-			return conn, nil
+			if waitForPut {
+				dialErr = err
+				continue
+			}
+			return nil, err
 		}
+		return c.checkoutNew(c.newIdleConn(conn, factory, gen))
 	}
 }
 
-// Put 将连接放回pool中
-func (c *channelPool) Put(conn interface{}) error {
-	if conn == nil {
-		return errors.New("connection is nil. rejecting")
-	}
-
+// checkoutNew 把刚新建的连接登记为借出并执行 OnGet. 新建的连接准备失败没有别的可换, 关闭后直接返回错误
+func (c *channelPool) checkoutNew(wrapConn *idleConn) (interface{}, error) {
+	conn := wrapConn.conn
+	wrapConn.useCount = 1
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.active[conn] = wrapConn
+	wrapConn.dialed = true
+	c.transitionLocked(wrapConn, ConnInUse)
+	c.mu.Unlock()
+	if c.onGet != nil {
+		if err := c.onGet(conn); err != nil {
+			_ = c.Close(conn)
+			return nil, err
+		}
+	}
+	c.setDeadline(conn)
+	return conn, nil
+}
 
-	if c.conns == nil {
-		return c.Close(conn)
+// reserveDialLocked 按 MinDialInterval 预约一次拨号, 返回拨号前需要等待的时长.
+// 可拨号的时刻晚于 deadline 时返回 ErrDialRateLimited, deadline 为零表示不限等待时长. 调用方需持有 c.mu
+func (c *channelPool) reserveDialLocked(deadline time.Time) (time.Duration, error) {
+	if c.minDialInterval <= 0 {
+		return 0, nil
 	}
+	now := time.Now()
+	at := c.nextDial
+	if at.Before(now) {
+		at = now
+	}
+	if at.After(now) && !deadline.IsZero() && at.After(deadline) {
+		return 0, ErrDialRateLimited
+	}
+	c.nextDial = at.Add(c.minDialInterval)
+	return at.Sub(now), nil
+}
 
-	// 如果有请求连接的缓冲区有等待，则按顺序有限个先来的请求分配当前放回的连接
-	// if l := len(c.connReqs); l > 0 { ///说明有空位,可以放连接
-
-	// 	req := c.connReqs[0] //把第0位的channel取出来.
-	// 	copy(c.connReqs, c.connReqs[1:])
-	// 	c.connReqs = c.connReqs[:l-1]
-
-	// 	//放连接进去
-	// 	req <- connReq{
-	// 		idleConn: &idleConn{conn: conn, t: time.Now()},
-	// 	}
-	// 	return nil
-	// }
-	// 如果没有等待的缓冲则尝试放入空闲连接缓冲
-	select {
-	case c.conns <- &idleConn{conn: conn, t: time.Now()}:
-		return nil
-	default:
-		//连接池已满，直接关闭该连接
-		return c.Close(conn)
+// validate 检查空闲连接能否借出: 空闲超时、Ping 失败、OnGet 失败的都丢弃并返回 false
+func (c *channelPool) validate(wrapConn *idleConn) bool {
+	//判断是否超时，超时则丢弃. 不受 MaxDiscardRate 限制, 已经过期的连接不能借出
+	if c.idleExpired(wrapConn) || wrapConn.lifetimeExpired() { //空闲时间/存活时间不为0,才校验
+		c.discard(wrapConn, "expired")
+		return false
+	}
+	//丢弃速率已达 MaxDiscardRate 时不再做健康检查, 原样复用, 避免后端抖动时反复关闭和拨号
+	if c.discardBudgetLeft() && !c.checkIdleConn(wrapConn) {
+		c.spendDiscardToken()
+		return false
+	}
+	//借出前的准备工作失败, 同样丢弃换下一条
+	if c.onGet != nil {
+		if err := c.onGet(wrapConn.conn); err != nil {
+			wrapConn.lastErr = err
+			c.discard(wrapConn, "OnGet failed: "+err.Error())
+			return false
+		}
 	}
+	return true
+}
 
+// checkIdleConn validate 的 Ping 检查, 不通过的丢弃并返回 false
+func (c *channelPool) checkIdleConn(wrapConn *idleConn) bool {
+	//判断是否失效，失效则丢弃，如果用户没有设定 ping 方法，就不检查. 刚校验过的跳过, 空闲太久的仍要检查
+	if c.validationTTL <= 0 || time.Since(wrapConn.lastValidated) >= c.validationTTL ||
+		(c.pingAfterIdle > 0 && time.Since(wrapConn.lastUsed) > c.pingAfterIdle) {
+		if err := c.ping(wrapConn); err != nil {
+			c.discard(wrapConn, "ping failed: "+err.Error())
+			if c.refillOnPingFail {
+				c.refill()
+			}
+			return false
+		}
+		if c.pingFailuresExceeded(wrapConn) {
+			c.discard(wrapConn, "too many ping failures")
+			return false
+		}
+		wrapConn.lastValidated = time.Now()
+	}
+	return true
 }
 
-// Close 关闭单条连接
-func (c *channelPool) Close(conn interface{}) error {
-	if conn == nil {
-		return errors.New("connection is nil. rejecting")
+// discardBudgetLeft MaxDiscardRate 的令牌桶里还有令牌时返回 true, 未开启时总是 true
+func (c *channelPool) discardBudgetLeft() bool {
+	if c.maxDiscardRate <= 0 {
+		return true
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.openingConns--
-	return c.factory.Close(conn)
+	c.refillDiscardTokensLocked()
+	return c.discardTokens >= 1
 }
 
-// Ping 检查单条连接是否有效
-func (c *channelPool) Ping(conn interface{}) error {
+// spendDiscardToken Get 丢弃一条连接后扣掉一个令牌. 并发的 Get 可能同时通过检查, 令牌允许暂时为负, 之后补回
+func (c *channelPool) spendDiscardToken() {
+	if c.maxDiscardRate <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refillDiscardTokensLocked()
+	c.discardTokens--
+}
+
+// refillDiscardTokensLocked 按 MaxDiscardRate 每秒补充令牌, 最多补满一秒的量. 调用方需持有 c.mu
+func (c *channelPool) refillDiscardTokensLocked() {
+	now := time.Now()
+	rate := float64(c.maxDiscardRate)
+	c.discardTokens = min(rate, c.discardTokens+now.Sub(c.discardRefilled).Seconds()*rate)
+	c.discardRefilled = now
+}
+
+// popIdle 从空闲缓冲取出一条连接, 没有空闲连接时返回 false; 缓冲已关闭时返回 nil 和 true.
+// 开启 BalanceWindow 时取最早的 balanceWindow 条里借出次数最少的
+func (c *channelPool) popIdle(conns chan *idleConn) (*idleConn, bool) {
+	if c.balanceWindow > 1 {
+		wrapConn := c.takeLeastUsed(c.balanceWindow)
+		return wrapConn, wrapConn != nil
+	}
+	select {
+	case wrapConn := <-conns:
+		if wrapConn != nil {
+			c.idleCount.Add(-1)
+		}
+		//只有开启 MaxIdleBytes 或有 Put 在等空位时才需要加锁
+		if wrapConn != nil && (wrapConn.size > 0 || c.putWaiters.Load() > 0) {
+			c.mu.Lock()
+			c.idleBytes -= wrapConn.size
+			c.notifySpaceLocked()
+			c.mu.Unlock()
+		}
+		return wrapConn, true
+	default:
+		return nil, false
+	}
+}
+
+// takeLeastUsed 在最早放回的 window 条空闲连接里取出借出次数最少的一条, 次数相同取更早的. 没有空闲连接时返回 nil
+func (c *channelPool) takeLeastUsed(window int) *idleConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := c.idleSnapshotLocked()
+	if len(snapshot) > window {
+		snapshot = snapshot[:window]
+	}
+	var best *idleConn
+	for _, wrapConn := range snapshot {
+		if best == nil || wrapConn.useCount < best.useCount {
+			best = wrapConn
+		}
+	}
+	if best == nil || len(c.filterIdleLocked(func(wrapConn *idleConn) bool { return wrapConn != best })) == 0 {
+		return nil
+	}
+	return best
+}
+
+// checkout 把通过校验的连接登记为借出, 返回给调用方
+func (c *channelPool) checkout(wrapConn *idleConn) interface{} {
+	c.mu.Lock()
+	wrapConn.useCount++
+	c.active[wrapConn.conn] = wrapConn
+	wrapConn.dialed = false
+	c.transitionLocked(wrapConn, ConnInUse)
+	c.mu.Unlock()
+	c.setDeadline(wrapConn.conn)
+	return wrapConn.conn
+}
+
+// wait 连接数已达上限时排队等待, 调用时需持有 c.mu, 返回前会释放.
+// 返回放回来的连接; 返回 nil 表示有名额空出, 调用方应重新尝试; 超时返回 ErrMaxActiveConnReached
+func (c *channelPool) wait(ctx context.Context, deadline time.Time) (*idleConn, error) {
+	// 创建一个缓冲channel排在等待队列里, 放回去的连接或空出的名额会先发给它(逻辑在 Put/Close 内)
+	req := make(chan connReq, 1)
+	c.connReqs = append(c.connReqs, req)
+	c.waitCount++
+	c.notifySpaceLocked() //放回的连接可以直接交给这个等待者
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case ret, ok := <-req:
+		if !ok { //连接池被释放
+			return nil, ErrClosed
+		}
+		return ret.idleConn, ret.err
+	case <-timer.C:
+		c.mu.Lock()
+		removed := c.removeWaiterLocked(req)
+		c.mu.Unlock()
+		if removed {
+			return nil, ErrMaxActiveConnReached
+		}
+		// 超时的同时已经被分配了, 照常接收
+		ret, ok := <-req
+		if !ok {
+			return nil, ErrClosed
+		}
+		return ret.idleConn, ret.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		removed := c.removeWaiterLocked(req)
+		c.mu.Unlock()
+		if removed {
+			return nil, ctx.Err()
+		}
+		// 取消的同时已经被分配了, 把分到的连接或名额让给别人
+		ret, ok := <-req
+		if !ok {
+			return nil, ErrClosed
+		}
+		if ret.err != nil {
+			return nil, ret.err
+		}
+		c.handBack(ret.idleConn)
+		return nil, ctx.Err()
+	}
+}
+
+// handBack 已取消的等待者把分到的连接放回, 分到的是空出的名额(wrapConn 为 nil)时转交下一个等待者
+func (c *channelPool) handBack(wrapConn *idleConn) {
+	if wrapConn != nil {
+		_ = c.requeueIdleConn(wrapConn)
+		return
+	}
+	c.mu.Lock()
+	if req := c.popWaiterLocked(); req != nil {
+		req <- connReq{}
+	}
+	c.notifyReadyLocked()
+	c.mu.Unlock()
+}
+
+// sleepContext 等待 d, ctx 先结束时返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// FailWaiters 让所有正在等待的 Get 立即返回 err(为 nil 时用 ErrMaxActiveConnReached), 返回等待者个数
+func (c *channelPool) FailWaiters(err error) int {
+	if err == nil {
+		err = ErrMaxActiveConnReached
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.connReqs)
+	for _, req := range c.connReqs {
+		req <- connReq{err: err}
+	}
+	c.connReqs = nil
+	return n
+}
+
+// removeWaiterLocked 从等待队列中移除 req, 已经被取走(分配过)时返回 false. 调用方需持有 c.mu
+func (c *channelPool) removeWaiterLocked(req chan connReq) bool {
+	for i, r := range c.connReqs {
+		if r == req {
+			c.connReqs = append(c.connReqs[:i], c.connReqs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// popWaiterLocked 取出最早的等待者, 没有时返回 nil. 调用方需持有 c.mu
+func (c *channelPool) popWaiterLocked() chan connReq {
+	if len(c.connReqs) == 0 {
+		return nil
+	}
+	req := c.connReqs[0]
+	copy(c.connReqs, c.connReqs[1:])
+	c.connReqs = c.connReqs[:len(c.connReqs)-1]
+	return req
+}
+
+// releaseSlotLocked 归还一个 openingConns 名额, 有等待者时通知它重新尝试. 调用方需持有 c.mu
+func (c *channelPool) releaseSlotLocked() {
+	c.openingConns--
+	if req := c.popWaiterLocked(); req != nil {
+		req <- connReq{}
+	}
+	c.notifyReadyLocked()
+	c.checkDrainedLocked()
+}
+
+// notifyReadyLocked 唤醒所有 WaitReady, 没人等待时什么都不做. 调用方需持有 c.mu
+func (c *channelPool) notifyReadyLocked() {
+	if c.readyCh != nil {
+		close(c.readyCh)
+		c.readyCh = nil
+	}
+}
+
+// WaitReady 等到连接池能立即提供连接(有空闲连接或未达上限可新建)时返回 nil, 不会借出连接.
+// ctx 结束时返回 ctx.Err(), 连接池已释放返回 ErrClosed
+func (c *channelPool) WaitReady(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.conns == nil {
+			c.mu.Unlock()
+			return ErrClosed
+		}
+		if len(c.conns) > 0 || (!c.degraded && c.openingConns < c.maxActive) {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.readyCh == nil {
+			c.readyCh = make(chan struct{})
+		}
+		ch := c.readyCh
+		c.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// SetDegraded 切换降级状态. 降级期间 Get 快速失败返回 ErrDegraded,
+// 配置了 ServeIdleWhenDegraded 时仍借出已有的空闲连接
+func (c *channelPool) SetDegraded(degraded bool) {
+	c.mu.Lock()
+	c.degraded = degraded
+	c.notifyReadyLocked()
+	c.mu.Unlock()
+	c.notifyHealth()
+}
+
+// GetOrCreate 优先取空闲连接, 连接数达到上限时仍然新建一条并返回 true. 从不阻塞: 不等待连接放回(忽略 WaitTimeout),
+// 也不等待 MinDialInterval. 超限连接同样计入 openingConns 并执行 OnGet, 调用方用完应 Close 而不是 Put
+func (c *channelPool) GetOrCreate() (interface{}, bool, error) {
+	var overCap bool
+	conn, err := c.observeGetting(func() (conn interface{}, err error) {
+		conn, overCap, err = c.getOrCreate()
+		return conn, err
+	})
+	return conn, overCap, err
+}
+
+// getOrCreate GetOrCreate 的实现
+func (c *channelPool) getOrCreate() (interface{}, bool, error) {
+	start := c.slowGetStart()
+	defer c.slowGetDone(start)
+	conn, err := c.get(context.Background(), 0) //不等待放回, 到达上限立即转为超限新建
+	if err != ErrMaxActiveConnReached {
+		return conn, false, err
+	}
+
+	c.mu.Lock()
+	factory, gen := c.factory, c.factoryGen
+	if factory == nil {
+		c.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+	if _, err := c.reserveDialLocked(time.Now()); err != nil { //同样受拨号频率限制, 但不等待
+		c.mu.Unlock()
+		return nil, false, err
+	}
+	c.openingConns++
+	c.mu.Unlock()
+	conn, err = c.create(factory)
+	if err != nil {
+		c.mu.Lock()
+		c.releaseSlotLocked()
+		c.mu.Unlock()
+		return nil, false, err
+	}
+	wrapConn := c.newIdleConn(conn, factory, gen)
+	wrapConn.overCap = true
+	if _, err := c.checkoutNew(wrapConn); err != nil {
+		return nil, false, err
+	}
+	return conn, true, nil
+}
+
+// GetWithInfo 取一个连接, 同时返回它的创建时刻、使用次数等元信息
+func (c *channelPool) GetWithInfo() (interface{}, ConnInfo, error) {
+	conn, err := c.Get()
+	if err != nil {
+		return nil, ConnInfo{}, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	wrapConn, ok := c.active[conn]
+	if !ok {
+		return conn, ConnInfo{}, nil
+	}
+	return conn, wrapConn.info(), nil
+}
+
+// GetLongestLived 从空闲连接中取离 MaxConnLifetime 到期最久的一条(已计入随机抖动), 适合长时间占用连接的操作.
+// 不限制存活时间时取最新创建的. 没有可用的空闲连接时按 Get 取连接
+func (c *channelPool) GetLongestLived() (interface{}, error) {
+	return c.observeGetting(c.getLongestLived)
+}
+
+// getLongestLived GetLongestLived 的实现
+func (c *channelPool) getLongestLived() (interface{}, error) {
+	if err := c.checkServing(); err != nil {
+		return nil, err
+	}
+	conns := c.getConns()
+	if conns == nil {
+		return nil, ErrClosed
+	}
+	for i := cap(conns); i > 0; i-- {
+		wrapConn := c.takeLongestLived()
+		if wrapConn == nil {
+			break
+		}
+		if c.validate(wrapConn) {
+			return c.checkout(wrapConn), nil
+		}
+	}
+	return c.getContext(context.Background())
+}
+
+// takeLongestLived 在锁内比较所有空闲连接的剩余存活时间, 取出最长的一条, 没有空闲连接时返回 nil
+func (c *channelPool) takeLongestLived() *idleConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var best *idleConn
+	for _, wrapConn := range c.idleSnapshotLocked() {
+		if best == nil {
+			best = wrapConn
+			continue
+		}
+		remaining, bestRemaining := wrapConn.remainingLifetime(now), best.remainingLifetime(now)
+		if remaining > bestRemaining || (remaining == bestRemaining && wrapConn.t.After(best.t)) {
+			best = wrapConn
+		}
+	}
+	if best == nil || len(c.filterIdleLocked(func(wrapConn *idleConn) bool { return wrapConn != best })) == 0 {
+		return nil
+	}
+	return best
+}
+
+// GetFresh 先关闭空闲时长超过 maxAge 的空闲连接(即使还没到 IdleTimeout), 再按 Get 取连接,
+// 没有足够新的空闲连接时会新建. 被关闭的是整个空闲缓冲里的旧连接, 其他调用方也不会再拿到它们;
+// 关闭立即进行, 不受 CloseLinger 影响, 空出的名额马上可以用来新建
+func (c *channelPool) GetFresh(maxAge time.Duration) (interface{}, error) {
+	c.mu.Lock()
+	stale := c.filterIdleLocked(func(wrapConn *idleConn) bool {
+		return c.idleAge(wrapConn) <= maxAge
+	})
+	c.mu.Unlock()
+	if len(stale) > 0 {
+		c.closeNow(stale)
+	}
+	return c.Get()
+}
+
+// setDeadline 配置了 ApplyDeadline 和 UseTimeout 时, 给即将借出的连接设置使用截止时间
+func (c *channelPool) setDeadline(conn interface{}) {
+	if c.applyDeadline == nil || c.useTimeout <= 0 {
+		return
+	}
+	c.applyDeadline(conn, time.Now().Add(c.useTimeout))
+}
+
+// create 拨号, 按配置包装连接并执行 OnCreate, 任一步失败时关闭连接并返回其错误. 名额由调用方负责
+func (c *channelPool) create(factory ConnectionFactory) (interface{}, error) {
+	return c.createContext(context.Background(), factory)
+}
+
+// createContext 与 create 相同, 拨号时把 ctx 交给实现了 ContextFactory 的工厂.
+// ctx 已结束时结果不计入健康状态: 调用方取消引起的失败不是后端的问题, 取消之后才拨通的连接也会被丢弃
+func (c *channelPool) createContext(ctx context.Context, factory ConnectionFactory) (interface{}, error) {
+	conn, err := c.createConn(ctx, factory)
+	if ctx.Err() == nil {
+		c.recordDial(err)
+	}
+	return conn, err
+}
+
+// createConn createContext 的实现, 不记录健康状态
+func (c *channelPool) createConn(ctx context.Context, factory ConnectionFactory) (interface{}, error) {
+	conn, err := c.dial(ctx, factory)
+	if err != nil {
+		return nil, err
+	}
+	if c.decorate != nil {
+		decorated, err := c.decorate(conn)
+		if err != nil {
+			_ = c.closeFactoryConn(factory, conn)
+			return nil, err
+		}
+		conn = decorated
+	}
+	if !hashable(conn) { //不可比较的连接放不进借出表, 直接拒绝, 免得之后 panic
+		_ = c.closeFactoryConn(factory, conn)
+		return nil, ErrUnhashableConn
+	}
+	if c.onCreate != nil {
+		if err := c.onCreate(conn); err != nil {
+			_ = c.closeFactoryConn(factory, conn)
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	c.forgetClosedLocked(conn)
+	c.mu.Unlock()
+	return conn, nil
+}
+
+// hashable 判断连接能否作为 map 的 key (借出表和关闭记录都以连接为 key)
+func hashable(conn interface{}) bool {
+	t := reflect.TypeOf(conn)
+	if t == nil {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Array:
+		//成员里可能装着不可比较的动态值, 只能看具体值
+		return reflect.ValueOf(conn).Comparable()
+	default:
+		//指针、chan 等走这里, 不分配, 保证 Get/Put 热路径零分配
+		return t.Comparable()
+	}
+}
+
+// dialIdle 在不超过 maxActive 的前提下新建一条连接放入连接池(优先给等待者)
+func (c *channelPool) dialIdle() error {
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if c.openingConns >= c.maxActive {
+		c.mu.Unlock()
+		return ErrMaxActiveConnReached
+	}
+	factory, gen := c.factory, c.factoryGen
+	c.openingConns++
+	c.mu.Unlock()
+	return c.dialReserved(factory, gen)
+}
+
+// dialReserved 用已经占好的名额新建一条连接放入连接池, 失败时归还名额
+func (c *channelPool) dialReserved(factory ConnectionFactory, gen int) error {
+	c.waitDialTurn()
+	conn, err := c.create(factory)
+	if err != nil {
+		c.mu.Lock()
+		c.releaseSlotLocked()
+		c.mu.Unlock()
+		return err
+	}
+	return c.putIdleConn(c.newIdleConn(conn, factory, gen))
+}
+
+// refill 连接数低于 InitialCap 时在后台新建一条连接补上, 名额在返回前占好, 并发调用不会补过头
+func (c *channelPool) refill() {
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil || c.openingConns >= c.initialCap || c.openingConns >= c.maxActive {
+		c.mu.Unlock()
+		return
+	}
+	factory, gen := c.factory, c.factoryGen
+	c.openingConns++
+	c.mu.Unlock()
+	go func() {
+		_ = c.dialReserved(factory, gen)
+	}()
+}
+
+// waitDialTurn 按 MinDialInterval 预约一次拨号并等到预约的时刻, 用于没有等待时限的后台拨号
+func (c *channelPool) waitDialTurn() {
+	c.mu.Lock()
+	wait, _ := c.reserveDialLocked(time.Time{})
+	c.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// reserveHedgeSlot 对冲拨号是额外的一次拨号, 需要另占一个 openingConns 名额, 并且 MinDialInterval 允许立即拨号.
+// 成功时返回 true, 调用方在第二次拨号结束后用 releaseHedgeSlot 归还
+func (c *channelPool) reserveHedgeSlot() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.openingConns >= c.maxActive {
+		return false
+	}
+	if _, err := c.reserveDialLocked(time.Now()); err != nil {
+		return false
+	}
+	c.openingConns++
+	return true
+}
+
+// releaseHedgeSlot 归还 reserveHedgeSlot 占用的名额
+func (c *channelPool) releaseHedgeSlot() {
+	c.mu.Lock()
+	c.releaseSlotLocked()
+	c.mu.Unlock()
+}
+
+// dial 创建新连接. 设置了 hedgeDelay 时, 第一次拨号超时未返回且还有空余名额, 就再并行拨一次,
+// 用先成功的那条, 另一条完成后直接关闭. 对冲拨号另占一个名额, 落后的那条关闭后才归还,
+// 因此同时存在的连接(含拨号中的)不会超过 maxActive
+func (c *channelPool) dial(ctx context.Context, factory ConnectionFactory) (interface{}, error) {
+	if c.hedgeDelay <= 0 {
+		return factoryConn(ctx, factory)
+	}
+
+	type dialResult struct {
+		conn interface{}
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	start := func() {
+		go func() {
+			conn, err := factoryConn(ctx, factory)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	start()
+	pending, hedged := 1, false
+	// done 两次拨号都已返回时归还对冲名额
+	done := func() {
+		if hedged {
+			c.releaseHedgeSlot()
+		}
+	}
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if !hedged && c.reserveHedgeSlot() {
+				hedged = true
+				pending++
+				start()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending == 0 {
+					done()
+					return r.conn, nil
+				}
+				//落后的那次拨号成功后关闭, 不进入连接池, 之后才归还名额
+				go func() {
+					if loser := <-results; loser.err == nil {
+						_ = c.closeFactoryConn(factory, loser.conn)
+					}
+					done()
+				}()
+				return r.conn, nil
+			}
+			if pending == 0 {
+				done()
+				return nil, r.err
+			}
+		}
+	}
+}
+
+// factoryConn 用工厂拨一条原始连接, 工厂实现了 ContextFactory 时传入 ctx
+func factoryConn(ctx context.Context, factory ConnectionFactory) (interface{}, error) {
+	if cf, ok := factory.(ContextFactory); ok {
+		return cf.FactoryContext(ctx)
+	}
+	return factory.Factory()
+}
+
+// Put 将连接放回pool中
+func (c *channelPool) Put(conn interface{}) error {
 	if conn == nil {
 		return errors.New("connection is nil. rejecting")
 	}
+	if !hashable(conn) {
+		return ErrUnhashableConn
+	}
+	if c.observePut == nil {
+		return c.returnConn(conn)
+	}
+	start := time.Now()
+	err := c.returnConn(conn)
+	c.observePut(c.putOutcome(conn), time.Since(start))
+	return err
+}
 
-	return c.factory.Ping(conn)
+// returnConn Put 的实现, conn 已确认不为 nil 且可比较
+func (c *channelPool) returnConn(conn interface{}) error {
+	c.collectBytes(conn)
+	reason := ""
+	if c.reportsUnhealthy(conn) { //连接自己知道已经不可用, 不必再 Ping
+		reason = "reported unhealthy on put"
+	} else if c.activePingFailuresExceeded(conn) { //借出期间 Ping 失败过多次
+		reason = "too many ping failures"
+	} else if c.checkIdleOnPut && c.activeIdleExpired(conn) { //借出期间已经超过空闲时间
+		reason = "idle timeout exceeded on put"
+	}
+	if reason != "" {
+		c.mu.Lock()
+		wrapConn := c.untrackLocked(conn)
+		if wrapConn == nil {
+			wrapConn = &idleConn{conn: conn}
+		}
+		c.mu.Unlock()
+		c.discard(wrapConn, reason)
+		return nil
+	}
+	if c.validateOnPut {
+		if err := c.Ping(conn); err != nil {
+			return c.replaceOnPut(conn)
+		}
+	}
+	return c.put(conn)
+}
+
+// reportsUnhealthy 连接的工厂实现了 SelfChecker 且报告连接不可用时返回 true
+func (c *channelPool) reportsUnhealthy(conn interface{}) bool {
+	c.mu.RLock()
+	var factory ConnectionFactory
+	if wrapConn, ok := c.active[conn]; ok {
+		factory = c.factoryOfLocked(wrapConn)
+	} else {
+		factory = c.factory
+	}
+	c.mu.RUnlock()
+	checker, ok := factory.(SelfChecker)
+	return ok && !checker.Healthy(conn)
+}
+
+// replaceOnPut 放回时校验失败: 关闭原连接, OnPutValidationFail 给出替代连接时改为放回替代连接
+func (c *channelPool) replaceOnPut(conn interface{}) error {
+	var replacement interface{}
+	if c.onPutValidationFail != nil {
+		if r, err := c.onPutValidationFail(conn); err == nil {
+			replacement = r
+		}
+	}
+
+	c.mu.Lock()
+	wrapConn := c.untrackLocked(conn)
+	if wrapConn == nil {
+		wrapConn = &idleConn{conn: conn}
+	}
+	c.mu.Unlock()
+	closeErr := c.closeIdleConn(wrapConn)
+	if replacement == nil {
+		return closeErr
+	}
+
+	// 替代连接是新打开的, 计入 openingConns 后按正常流程放回
+	c.mu.Lock()
+	c.openingConns++
+	c.forgetClosedLocked(replacement)
+	wrapReplacement := c.newIdleConn(replacement, c.factory, c.factoryGen)
+	c.mu.Unlock()
+	return c.putIdleConn(wrapReplacement)
+}
+
+// put 把借出的连接放回空闲缓冲或交给等待者, 放不下时关闭
+func (c *channelPool) put(conn interface{}) error {
+	c.mu.Lock()
+	// 借出时记录过的连接沿用原来的创建时刻, 只刷新最后使用时刻
+	wrapConn := c.untrackLocked(conn)
+	if wrapConn == nil {
+		if _, ok := c.closedKeys[conn]; ok { //已经关闭过的连接不能再放回
+			c.mu.Unlock()
+			return ErrAlreadyClosed
+		}
+		wrapConn = c.newIdleConn(conn, c.factory, c.factoryGen)
+		wrapConn.lastValidated = time.Time{} //不是本连接池借出的, 没有校验过
+	}
+	c.mu.Unlock()
+	if c.resetOnPut == nil {
+		return c.returnIdleConn(wrapConn)
+	}
+	if c.resetSem != nil {
+		select {
+		case c.resetSem <- struct{}{}:
+			go func() {
+				defer func() { <-c.resetSem }()
+				_ = c.resetIdleConn(wrapConn)
+			}()
+			return nil
+		default: //后台重置已满, 退回同步重置
+		}
+	}
+	return c.resetIdleConn(wrapConn)
+}
+
+// resetIdleConn 执行 ResetOnPut, 成功后放回连接池, 失败则关闭连接并返回错误
+func (c *channelPool) resetIdleConn(wrapConn *idleConn) error {
+	if err := c.resetOnPut(wrapConn.conn); err != nil {
+		_ = c.closeIdleConn(wrapConn)
+		return err
+	}
+	return c.returnIdleConn(wrapConn)
+}
+
+// returnIdleConn 放回借出的连接. 设置了 PutDelay 时搁置到期后再放回, 搁置数已满时立即放回
+func (c *channelPool) returnIdleConn(wrapConn *idleConn) error {
+	if c.putDelay <= 0 {
+		c.waitIdleSpace()
+		return c.putIdleConn(wrapConn)
+	}
+	c.mu.Lock()
+	if c.conns == nil || len(c.delayedPuts) >= maxDelayedPuts {
+		c.mu.Unlock()
+		c.waitIdleSpace()
+		return c.putIdleConn(wrapConn)
+	}
+	if c.delayedPuts == nil {
+		c.delayedPuts = make(map[*idleConn]struct{})
+	}
+	c.delayedPuts[wrapConn] = struct{}{}
+	wrapConn.lastUsed = time.Now() //空闲时间从 Put 时刻算起, 搁置期间也计入
+	c.mu.Unlock()
+	c.afterFunc(c.putDelay, func() {
+		c.mu.Lock()
+		_, held := c.delayedPuts[wrapConn]
+		delete(c.delayedPuts, wrapConn)
+		c.mu.Unlock()
+		if held { //Release 已经关闭了搁置中的连接
+			_ = c.requeueIdleConn(wrapConn)
+		}
+	})
+	return nil
+}
+
+// waitIdleSpace 配置了 PutWait 时, 空闲缓冲已满且没有等待者就最多等待 PutWait, 直到并发的 Get 取走连接空出位置.
+// 开启 EvictOldestOnPut 时放回总能换下最旧的连接, 不必等待. 只是尽量等待, 返回后放入时仍可能已满, 此时照常关闭
+func (c *channelPool) waitIdleSpace() {
+	if c.putWait <= 0 || c.evictOldestOnPut {
+		return
+	}
+	c.putWaiters.Add(1)
+	defer c.putWaiters.Add(-1)
+	timer := time.NewTimer(c.putWait)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		if c.conns == nil || cap(c.conns) == 0 || len(c.conns) < c.maxIdle || len(c.connReqs) > 0 {
+			c.mu.Unlock()
+			return
+		}
+		if c.spaceCh == nil {
+			c.spaceCh = make(chan struct{})
+		}
+		ch := c.spaceCh
+		c.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// notifySpaceLocked 唤醒所有等待空位的 Put, 没人等待时什么都不做. 调用方需持有 c.mu
+func (c *channelPool) notifySpaceLocked() {
+	if c.spaceCh != nil {
+		close(c.spaceCh)
+		c.spaceCh = nil
+	}
+}
+
+// putIdleConn 把不在借出表里的连接交给等待者或放入空闲缓冲, 放不下时关闭
+func (c *channelPool) putIdleConn(wrapConn *idleConn) error {
+	return c.queueIdleConn(wrapConn, true)
+}
+
+// requeueIdleConn 与 putIdleConn 相同, 但保留原来的 lastUsed, 用于检查后放回或从别处接手的空闲连接
+func (c *channelPool) requeueIdleConn(wrapConn *idleConn) error {
+	return c.queueIdleConn(wrapConn, false)
+}
+
+// queueIdleConn putIdleConn 和 requeueIdleConn 的实现, touch 为 true 时把 lastUsed 记为现在
+func (c *channelPool) queueIdleConn(wrapConn *idleConn, touch bool) error {
+	size := c.sizeOf(wrapConn)
+	c.mu.Lock()
+	if c.conns == nil || wrapConn.evicted || wrapConn.overCap {
+		c.mu.Unlock()
+		return c.closeIdleConn(wrapConn)
+	}
+	if wrapConn.stale { //Flush 之前借出的连接, 关闭后在后台新建一条顶替
+		c.mu.Unlock()
+		err := c.closeIdleConn(wrapConn)
+		go func() {
+			_ = c.dialIdle()
+		}()
+		return err
+	}
+	if c.draining && c.openingConns > c.maxActive { //排空中, 超出目标的连接不再复用
+		c.mu.Unlock()
+		return c.closeIdleConn(wrapConn)
+	}
+	if touch {
+		wrapConn.lastUsed = time.Now()
+	}
+
+	// 如果有请求连接的缓冲区有等待，则按顺序有限个先来的请求分配当前放回的连接
+	if req := c.popWaiterLocked(); req != nil {
+		req <- connReq{idleConn: wrapConn}
+		c.mu.Unlock()
+		return nil
+	}
+	// 如果没有等待的缓冲则尝试放入空闲连接缓冲
+	if len(c.conns) >= c.maxIdle { //超出 Resize 调整后的空闲上限
+		if cap(c.conns) > 0 { //不复用模式下关闭是预期行为, 不计数
+			c.putDiscardedFull++
+		}
+		victim := wrapConn
+		wrapConn.size = size
+		if c.evictOldestOnPut && len(c.conns) == c.maxIdle { //LazyShrink 多出的部分仍然直接关闭放回的连接
+			victim = c.swapOldestIdleLocked(wrapConn)
+		}
+		c.mu.Unlock()
+		return c.closeIdleConn(victim)
+	}
+	if c.maxIdleBytes > 0 && c.idleBytes+size > c.maxIdleBytes { //放入后超出空闲连接的内存预算
+		c.mu.Unlock()
+		return c.closeIdleConn(wrapConn)
+	}
+	wrapConn.size = size
+	select {
+	case c.conns <- wrapConn:
+		c.idleCount.Add(1)
+		c.idleBytes += size
+		c.transitionLocked(wrapConn, ConnIdle)
+		c.notifyReadyLocked()
+		c.mu.Unlock()
+		return nil
+	default:
+		//连接池已满，直接关闭该连接
+		c.putDiscardedFull++
+		c.mu.Unlock()
+		return c.closeIdleConn(wrapConn)
+	}
+}
+
+// swapOldestIdleLocked 空闲缓冲里最早创建的连接比 wrapConn 更旧时, 把它取出并放入 wrapConn, 返回应关闭的那条. 调用方需持有 c.mu
+func (c *channelPool) swapOldestIdleLocked(wrapConn *idleConn) *idleConn {
+	oldest := wrapConn
+	for _, idle := range c.idleSnapshotLocked() {
+		if idle.t.Before(oldest.t) {
+			oldest = idle
+		}
+	}
+	if oldest == wrapConn {
+		return wrapConn
+	}
+	if removed := c.filterIdleLocked(func(idle *idleConn) bool { return idle != oldest }); len(removed) == 0 {
+		return wrapConn //已被并发的 Get 取走
+	}
+	c.conns <- wrapConn //刚取出一条, 一定有空位
+	c.idleCount.Add(1)
+	c.idleBytes += wrapConn.size
+	c.transitionLocked(wrapConn, ConnIdle)
+	return oldest
+}
+
+// sizeOf 开启 MaxIdleBytes 且工厂实现了 Sizer 时返回连接当前占用的字节数, 否则为 0
+func (c *channelPool) sizeOf(wrapConn *idleConn) int64 {
+	if c.maxIdleBytes <= 0 {
+		return 0
+	}
+	c.mu.RLock()
+	factory := c.factoryOfLocked(wrapConn)
+	c.mu.RUnlock()
+	if sizer, ok := factory.(Sizer); ok {
+		return sizer.Size(wrapConn.conn)
+	}
+	return 0
+}
+
+// Close 关闭单条连接
+func (c *channelPool) Close(conn interface{}) error {
+	if conn == nil {
+		return errors.New("connection is nil. rejecting")
+	}
+	if !hashable(conn) {
+		return ErrUnhashableConn
+	}
+	c.collectBytes(conn)
+	c.mu.Lock()
+	wrapConn := c.untrackLocked(conn)
+	if wrapConn == nil {
+		wrapConn = &idleConn{conn: conn}
+	}
+	c.mu.Unlock()
+	return c.closeIdleConn(wrapConn)
+}
+
+// closeIdleConn 关闭一条已移出借出表和空闲缓冲的连接.
+// 只在锁内扣减计数, factory 的 Close 放到锁外执行, 慢速关闭不会阻塞其他操作
+func (c *channelPool) closeIdleConn(wrapConn *idleConn) error {
+	c.mu.Lock()
+	if !c.claimCloseLocked(wrapConn.conn) { //另一条路径已经关闭过, 名额也已归还
+		c.mu.Unlock()
+		return ErrAlreadyClosed
+	}
+	c.recordLifetimeLocked(wrapConn)
+	c.transitionLocked(wrapConn, ConnClosed)
+	c.releaseSlotLocked()
+	factory := c.factoryOfLocked(wrapConn)
+	c.mu.Unlock()
+	if factory == nil {
+		return ErrClosed
+	}
+	return c.closeFactoryConn(factory, wrapConn.conn)
+}
+
+// claimCloseLocked 登记即将关闭的连接, 最近已经关闭过时返回 false, 调用方不应再关闭它. 调用方需持有 c.mu
+func (c *channelPool) claimCloseLocked(conn interface{}) bool {
+	if _, ok := c.closedKeys[conn]; ok {
+		return false
+	}
+	c.closedSeq++
+	c.closedKeys[conn] = c.closedSeq
+	c.closedRing = append(c.closedRing, closedEntry{conn: conn, seq: c.closedSeq})
+	if len(c.closedRing) > closedHistory {
+		oldest := c.closedRing[0]
+		if c.closedKeys[oldest.conn] == oldest.seq { //之后没有被重新打开再关闭过
+			delete(c.closedKeys, oldest.conn)
+		}
+		c.closedRing[0] = closedEntry{}
+		c.closedRing = c.closedRing[1:]
+	}
+	return true
+}
+
+// forgetClosedLocked 工厂重新交出了一个关闭过的连接值(如从空闲链表复用对象), 把它从关闭记录中去掉. 调用方需持有 c.mu
+func (c *channelPool) forgetClosedLocked(conn interface{}) {
+	if len(c.closedKeys) > 0 {
+		delete(c.closedKeys, conn)
+	}
+}
+
+// claimCloseAllLocked 对一批连接执行 claimCloseLocked, 返回其中尚未关闭过的. 调用方需持有 c.mu
+func (c *channelPool) claimCloseAllLocked(wrapConns []*idleConn) []*idleConn {
+	claimed := wrapConns[:0]
+	for _, wrapConn := range wrapConns {
+		if c.claimCloseLocked(wrapConn.conn) {
+			c.recordLifetimeLocked(wrapConn)
+			c.transitionLocked(wrapConn, ConnClosed)
+			claimed = append(claimed, wrapConn)
+		}
+	}
+	return claimed
+}
+
+// recordLifetimeLocked 把即将关闭的连接的存活时长计入统计, 没有创建时刻的(不是本连接池创建的)跳过. 调用方需持有 c.mu
+func (c *channelPool) recordLifetimeLocked(wrapConn *idleConn) {
+	if wrapConn.t.IsZero() {
+		return
+	}
+	c.closedLifetimeSum += time.Since(wrapConn.t)
+	c.closedLifetimeCount++
+}
+
+// factoryOfLocked 返回连接自己的工厂, 没有记录时用连接池当前的工厂. 调用方需持有 c.mu
+func (c *channelPool) factoryOfLocked(wrapConn *idleConn) ConnectionFactory {
+	if wrapConn.factory != nil {
+		return wrapConn.factory
+	}
+	return c.factory
+}
+
+// SetFactory 在锁内替换工厂, 之后新建的连接使用新工厂; 已有连接记着原来的工厂, 仍由它关闭和检查
+func (c *channelPool) SetFactory(factory ConnectionFactory) {
+	if factory == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil { //已经释放的连接池不再接受新工厂
+		return
+	}
+	c.factory = factory
+	c.factoryGen++
+}
+
+// untrackLocked 将连接移出借出表并归还租户名额, 返回其元信息, 不是借出的连接返回 nil. 调用方需持有 c.mu
+func (c *channelPool) untrackLocked(conn interface{}) *idleConn {
+	wrapConn, ok := c.active[conn]
+	if !ok {
+		return nil
+	}
+	delete(c.active, conn)
+	if wrapConn.tenant != "" {
+		if c.tenantInUse[wrapConn.tenant]--; c.tenantInUse[wrapConn.tenant] <= 0 {
+			delete(c.tenantInUse, wrapConn.tenant)
+		}
+		wrapConn.tenant = ""
+	}
+	return wrapConn
+}
+
+// Evict 强制关闭指定连接: 在空闲缓冲里则取出并关闭, 已借出则标记, 放回时关闭
+func (c *channelPool) Evict(conn interface{}) error {
+	if conn == nil {
+		return errors.New("connection is nil. rejecting")
+	}
+	if !hashable(conn) {
+		return ErrUnhashableConn
+	}
+	c.mu.Lock()
+	if wrapConn, ok := c.active[conn]; ok {
+		wrapConn.evicted = true
+		c.mu.Unlock()
+		return nil
+	}
+	removed := c.filterIdleLocked(func(wrapConn *idleConn) bool {
+		return wrapConn.conn != conn
+	})
+	c.mu.Unlock()
+	if len(removed) == 0 {
+		return ErrConnNotFound
+	}
+	return c.closeIdleConn(removed[0])
+}
+
+// filterIdleLocked 遍历一遍空闲缓冲, keep 返回 false 的连接被取出返回, 其余按原顺序放回. 调用方需持有 c.mu
+func (c *channelPool) filterIdleLocked(keep func(*idleConn) bool) []*idleConn {
+	var removed []*idleConn
+	if c.conns == nil {
+		return nil
+	}
+	for n := len(c.conns); n > 0; n-- {
+		var wrapConn *idleConn
+		select {
+		case wrapConn = <-c.conns:
+		default: //被并发的 Get 取空了
+			return removed
+		}
+		if keep(wrapConn) {
+			c.conns <- wrapConn
+		} else {
+			c.idleCount.Add(-1)
+			c.idleBytes -= wrapConn.size
+			removed = append(removed, wrapConn)
+		}
+	}
+	if len(removed) > 0 {
+		c.notifySpaceLocked()
+	}
+	return removed
+}
+
+// idleExpired 判断空闲连接是否已超过空闲时间, IdleTimeout 为 0 时不校验
+func (c *channelPool) idleExpired(wrapConn *idleConn) bool {
+	if c.idleTimeout <= 0 {
+		return false
+	}
+	return c.idleAge(wrapConn) > c.idleTimeout
+}
+
+// idleAge 计算空闲超时所用的时长, 默认从创建时刻算起, IdleFromLastUse 时从最后一次放回算起
+func (c *channelPool) idleAge(wrapConn *idleConn) time.Duration {
+	if c.idleFromLastUse {
+		return time.Since(wrapConn.lastUsed)
+	}
+	return time.Since(wrapConn.t)
+}
+
+// lifetimeExpired 判断连接是否已超过它的最长存活时间
+func (wrapConn *idleConn) lifetimeExpired() bool {
+	return wrapConn.lifetime > 0 && time.Since(wrapConn.t) >= wrapConn.lifetime
+}
+
+// remainingLifetime 距离存活时间到期还有多久, 不限制存活时间时为最大值
+func (wrapConn *idleConn) remainingLifetime(now time.Time) time.Duration {
+	if wrapConn.lifetime <= 0 {
+		return math.MaxInt64
+	}
+	return wrapConn.t.Add(wrapConn.lifetime).Sub(now)
+}
+
+// discard 丢弃一条连接: 同步扣减 openingConns, 在后台 goroutine 里关闭, Get 不用等待关闭完成.
+// 工厂实现了 Identifier 时记录连接标识和丢弃原因
+func (c *channelPool) discard(wrapConn *idleConn, reason string) {
+	c.mu.Lock()
+	if !c.claimCloseLocked(wrapConn.conn) {
+		c.mu.Unlock()
+		return
+	}
+	c.recordLifetimeLocked(wrapConn)
+	c.transitionLocked(wrapConn, ConnClosed)
+	c.releaseSlotLocked()
+	factory := c.factoryOfLocked(wrapConn)
+	c.mu.Unlock()
+	if factory == nil {
+		return
+	}
+	if id := connID(factory, wrapConn.conn); id != "" {
+		log.Printf("discarding connection %s: %s", id, reason)
+	}
+	go func() {
+		_ = c.closeFactoryConn(factory, wrapConn.conn)
+	}()
+}
+
+// Ping 检查单条连接是否有效
+func (c *channelPool) Ping(conn interface{}) error {
+	if conn == nil {
+		return errors.New("connection is nil. rejecting")
+	}
+	if !hashable(conn) {
+		return ErrUnhashableConn
+	}
+
+	c.mu.RLock()
+	wrapConn, ok := c.active[conn]
+	if !ok {
+		wrapConn = &idleConn{conn: conn}
+	}
+	c.mu.RUnlock()
+	return c.ping(wrapConn)
+}
+
+// ping 用连接自己的工厂检查连接
+func (c *channelPool) ping(wrapConn *idleConn) error {
+	c.mu.RLock()
+	factory := c.factoryOfLocked(wrapConn)
+	c.mu.RUnlock()
+	if factory == nil {
+		return ErrClosed
+	}
+	err := factory.Ping(wrapConn.conn)
+	if err != nil {
+		c.recordPingFailure(wrapConn, err)
+	}
+	c.checkShutdown(err)
+	return err
+}
+
+// recordPingFailure 记下连接最近一次 Ping 的错误, 并在统计窗口内累加失败次数, 窗口过期时重新计数
+func (c *channelPool) recordPingFailure(wrapConn *idleConn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wrapConn.lastErr = err
+	now := time.Now()
+	if wrapConn.pingFailures == 0 || (c.pingFailWindow > 0 && now.Sub(wrapConn.pingFailingSince) >= c.pingFailWindow) {
+		wrapConn.pingFailures = 0
+		wrapConn.pingFailingSince = now
+	}
+	wrapConn.pingFailures++
+}
+
+// pingFailuresExceeded 连接在统计窗口内的 Ping 失败次数超过 MaxPingFailures 时返回 true
+func (c *channelPool) pingFailuresExceeded(wrapConn *idleConn) bool {
+	if c.maxPingFailures <= 0 {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.pingFailWindow > 0 && time.Since(wrapConn.pingFailingSince) >= c.pingFailWindow {
+		return false //窗口已过期, 之前的失败不再计入
+	}
+	return wrapConn.pingFailures > c.maxPingFailures
+}
+
+// activeIdleExpired 借出的连接已超过空闲时间时返回 true, 不在借出表里的返回 false
+func (c *channelPool) activeIdleExpired(conn interface{}) bool {
+	c.mu.RLock()
+	wrapConn, ok := c.active[conn]
+	c.mu.RUnlock()
+	return ok && c.idleExpired(wrapConn)
+}
+
+// activePingFailuresExceeded 同 pingFailuresExceeded, 按借出的连接值查找
+func (c *channelPool) activePingFailuresExceeded(conn interface{}) bool {
+	c.mu.RLock()
+	wrapConn, ok := c.active[conn]
+	c.mu.RUnlock()
+	return ok && c.pingFailuresExceeded(wrapConn)
+}
+
+// checkShutdown err 表示后端正在关闭时在后台 Flush, 把所有连接换成新的后端连接. 同一时刻只进行一次
+func (c *channelPool) checkShutdown(err error) {
+	if !errors.Is(err, ErrBackendShuttingDown) {
+		return
+	}
+	c.mu.Lock()
+	if c.conns == nil || c.recycling {
+		c.mu.Unlock()
+		return
+	}
+	c.recycling = true
+	c.mu.Unlock()
+	go func() {
+		if err := c.Flush(); err != nil {
+			log.Printf("recycling connections after backend shutdown: %s", err)
+		}
+		c.mu.Lock()
+		c.recycling = false
+		c.mu.Unlock()
+	}()
 }
 
 // Release 释放连接池中所有连接
 func (c *channelPool) Release() {
+	c.ReleaseWithHook(nil)
+}
+
+// ReleaseWithHook 释放连接池中所有连接, fn 不为 nil 时在关闭每条空闲连接前调用(如发送 quit 命令)
+func (c *channelPool) ReleaseWithHook(fn func(conn interface{})) {
 	c.mu.Lock()
 	conns := c.conns
+	factory := c.factory
 	c.conns = nil
+	c.factory = nil
+	// 关闭所有等待者的 channel, 阻塞中的 Get 立即返回 ErrClosed
+	for _, req := range c.connReqs {
+		close(req)
+	}
+	c.connReqs = nil
+	c.notifyReadyLocked()
+	c.notifySpaceLocked()
+	if c.reaperDone != nil {
+		close(c.reaperDone)
+		c.reaperDone = nil
+	}
+	if c.scalerDone != nil {
+		close(c.scalerDone)
+		c.scalerDone = nil
+	}
+	if c.signalDone != nil {
+		close(c.signalDone)
+		c.signalDone = nil
+	}
+	if c.compactDone != nil {
+		close(c.compactDone)
+		c.compactDone = nil
+	}
+	lingering := c.lingering
+	c.lingering = nil
+	standby := c.standby
+	c.standby = nil
+	delayed := make([]*idleConn, 0, len(c.delayedPuts))
+	for wrapConn := range c.delayedPuts {
+		delayed = append(delayed, wrapConn)
+	}
+	c.delayedPuts = nil
+	expvarName := c.expvarName
+	c.expvarName = ""
 	c.mu.Unlock()
+	unpublishExpvar(expvarName)
+	c.notifyHealth()
 
-	defer func() {
-		c.factory = nil
-	}()
+	// 还在延迟关闭队列里的连接立即关闭
+	for _, batch := range lingering {
+		batch.timer.Stop()
+		c.closeNow(batch.conns)
+	}
+	c.closeNow(standby)
+	c.closeNow(delayed) //搁置中的连接不等 PutDelay 到期
 
 	if conns == nil {
 		return
 	}
 
 	close(conns)
+	var doomed []*idleConn
 	for wrapConn := range conns {
+		c.idleCount.Add(-1)
 		//log.Printf("Type %v\n",reflect.TypeOf(wrapConn.conn))
-		_ = c.factory.Close(wrapConn.conn)
+		if fn != nil {
+			fn(wrapConn.conn)
+		}
+		doomed = append(doomed, wrapConn)
 	}
+	c.mu.Lock()
+	doomed = c.claimCloseAllLocked(doomed)
+	c.openingConns -= len(doomed)
+	c.mu.Unlock()
+	c.closeIdleConns(factory, doomed)
+	c.stopCloseWorker() //等关闭队列清空
 }
 
-// Len 连接池中已有的连接数量
+// closeIdleConns 按创建时的工厂分组批量关闭连接, 没有记录工厂的用 fallback 关闭
+func (c *channelPool) closeIdleConns(fallback ConnectionFactory, wrapConns []*idleConn) {
+	type group struct {
+		factory ConnectionFactory
+		conns   []interface{}
+	}
+	var groups []*group
+	byGen := make(map[int]*group)
+	for _, wrapConn := range wrapConns {
+		factory, gen := wrapConn.factory, wrapConn.factoryGen
+		if factory == nil {
+			factory, gen = fallback, -1
+		}
+		g, ok := byGen[gen]
+		if !ok {
+			g = &group{factory: factory}
+			byGen[gen] = g
+			groups = append(groups, g)
+		}
+		g.conns = append(g.conns, wrapConn.conn)
+	}
+	for _, g := range groups {
+		if g.factory != nil {
+			_ = c.closeFactoryBatch(g.factory, g.conns)
+		}
+	}
+}
+
+// closeConns 关闭一批连接, 工厂实现了 BatchCloser 就一次关完, 否则逐条关闭, 返回第一个错误
+func closeConns(factory ConnectionFactory, conns []interface{}) error {
+	if len(conns) == 0 {
+		return nil
+	}
+	if bc, ok := factory.(BatchCloser); ok {
+		return bc.CloseBatch(conns)
+	}
+	var firstErr error
+	for _, conn := range conns {
+		if err := factory.Close(conn); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Len 连接池中已有的连接数量, 无锁读取 idleCount
 func (c *channelPool) Len() int {
-	return len(c.getConns())
+	return int(c.idleCount.Load())
+}
+
+// IdleLen 空闲缓冲中的连接数, 同 Len
+func (c *channelPool) IdleLen() int {
+	return int(c.idleCount.Load())
 }
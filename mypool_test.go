@@ -0,0 +1,1579 @@
+package mypool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// sliceConn 含切片的连接值, 不可比较
+type sliceConn struct{ buf []byte }
+
+type sliceFactory struct{ closed int }
+
+func (f *sliceFactory) Factory() (interface{}, error) { return sliceConn{buf: make([]byte, 1)}, nil }
+func (f *sliceFactory) Close(interface{}) error       { f.closed++; return nil }
+func (f *sliceFactory) Ping(interface{}) error        { return nil }
+
+func TestSlowCloseDoesNotBlockGet(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, IdleTimeout: time.Millisecond})
+	f.SetCloseDelay(300 * time.Millisecond)
+	time.Sleep(5 * time.Millisecond) //两条初始连接都已空闲超时
+
+	start := time.Now()
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("Get waited %s for the factory Close", d)
+	}
+	if got := p.Stats().OpenConns; got != 1 {
+		t.Fatalf("OpenConns = %d, want 1 (expired connections released their slots)", got)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("second Get = %v, want a new connection", err)
+	}
+	for i := 0; i < 2; i++ { //到达上限后的报错路径不能漏解锁
+		if _, err := p.Get(); err != ErrMaxActiveConnReached {
+			t.Fatalf("Get at MaxCap = %v, want ErrMaxActiveConnReached", err)
+		}
+	}
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "background closes", func() bool { return f.Closed() == 2 })
+}
+
+func TestIdleFromLastUse(t *testing.T) {
+	for _, fromLastUse := range []bool{false, true} {
+		p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2, IdleTimeout: time.Minute, IdleFromLastUse: fromLastUse})
+		conn, _ := p.Get()
+		if err := p.Put(conn); err != nil {
+			t.Fatal(err)
+		}
+		//创建于两小时前, 刚刚放回
+		wrapConn := <-p.conns
+		wrapConn.t = time.Now().Add(-2 * time.Hour)
+		p.conns <- wrapConn
+
+		next, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reused := next == conn; reused != fromLastUse {
+			t.Fatalf("IdleFromLastUse=%v: reused=%v", fromLastUse, reused)
+		}
+	}
+}
+
+func TestOnGetFailureTriesNextConn(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{
+		InitialCap: 2,
+		MaxIdle:    2,
+		MaxCap:     4,
+		OnGet: func(conn interface{}) error {
+			if conn.(*testutil.MockConn).ID <= 2 {
+				return errors.New("select db failed")
+			}
+			return nil
+		},
+	})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := conn.(*testutil.MockConn).ID; id != 3 {
+		t.Fatalf("Get returned conn %d, want the newly dialed conn 3", id)
+	}
+	waitFor(t, "rejected connections to close", func() bool { return f.Closed() == 2 })
+}
+
+func TestEvict(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 3, MaxCap: 4})
+	a, _ := p.Get()
+	b, _ := p.Get()
+	_ = p.Put(a)
+	_ = p.Put(b)
+
+	if err := p.Evict(a); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 1 || f.Closed() != 1 {
+		t.Fatalf("idle evict: Len = %d, closed %d", p.Len(), f.Closed())
+	}
+	conn, _ := p.Get()
+	if conn != b {
+		t.Fatal("Get returned the evicted connection")
+	}
+
+	//借出中的连接只做标记, 放回时关闭
+	if err := p.Evict(conn); err != nil {
+		t.Fatal(err)
+	}
+	if f.Closed() != 1 {
+		t.Fatal("checked-out connection was closed before Put")
+	}
+	_ = p.Put(conn)
+	if p.Len() != 0 || f.Closed() != 2 || p.Stats().OpenConns != 0 {
+		t.Fatalf("after Put: Len = %d, closed %d, OpenConns = %d", p.Len(), f.Closed(), p.Stats().OpenConns)
+	}
+	if err := p.Evict(&testutil.MockConn{}); err != ErrConnNotFound {
+		t.Fatalf("Evict of unknown conn = %v, want ErrConnNotFound", err)
+	}
+}
+
+// slowFirstFactory 第一次拨号很慢, 之后的拨号立即返回
+type slowFirstFactory struct {
+	*testutil.MockFactory
+	dials atomic.Int32
+}
+
+func (f *slowFirstFactory) Factory() (interface{}, error) {
+	if f.dials.Add(1) == 1 {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return f.MockFactory.Factory()
+}
+
+func TestHedgedDial(t *testing.T) {
+	f := &slowFirstFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 4, Factory: f, HedgeDelay: 10 * time.Millisecond})
+	start := time.Now()
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("hedged Get took %s", d)
+	}
+	//落后的那次拨号完成后被关闭, 两次拨号只占一个名额
+	waitFor(t, "losing dial to close", func() bool { return f.Closed() == 1 })
+	if f.Created() != 2 || p.Stats().OpenConns != 1 {
+		t.Fatalf("created %d, OpenConns = %d; want 2 and 1", f.Created(), p.Stats().OpenConns)
+	}
+}
+
+// flakyFactory 每隔一次拨号失败一次
+type flakyFactory struct {
+	*testutil.MockFactory
+	dials atomic.Int32
+}
+
+func (f *flakyFactory) Factory() (interface{}, error) {
+	if f.dials.Add(1)%2 == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return f.MockFactory.Factory()
+}
+
+func TestBestEffortFill(t *testing.T) {
+	f := &flakyFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: f, BestEffortFill: true})
+	if p.Len() != 2 || p.Stats().OpenConns != 2 {
+		t.Fatalf("Len = %d, OpenConns = %d; want the 2 successful dials", p.Len(), p.Stats().OpenConns)
+	}
+
+	strict := &flakyFactory{MockFactory: testutil.NewMockFactory()}
+	if _, err := NewChannelPool(&PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: strict}); err == nil {
+		t.Fatal("NewChannelPool without BestEffortFill ignored a failed dial")
+	}
+	if strict.Closed() != strict.Created() {
+		t.Fatalf("failed fill left connections open: created %d, closed %d", strict.Created(), strict.Closed())
+	}
+}
+
+func TestFillDialHookSequential(t *testing.T) {
+	var got []int
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4,
+		OnFillDial: func(index int) { got = append(got, index) }})
+	if fmt.Sprint(got) != "[0 1 2 3]" {
+		t.Fatalf("OnFillDial indices = %v, want [0 1 2 3]", got)
+	}
+	if p.Len() != 4 || f.Created() != 4 {
+		t.Fatalf("Len = %d, created %d; want 4", p.Len(), f.Created())
+	}
+}
+
+func TestFillInterleaved(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[int]int{}
+	f := testutil.NewMockFactory()
+	f.SetDialDelay(20 * time.Millisecond)
+	start := time.Now()
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: f, FillOrder: FillInterleaved,
+		OnFillDial: func(index int) {
+			mu.Lock()
+			seen[index]++
+			mu.Unlock()
+		}})
+	if elapsed := time.Since(start); elapsed >= 80*time.Millisecond {
+		t.Fatalf("interleaved fill took %v, dials were not concurrent", elapsed)
+	}
+	if len(seen) != 4 || seen[0] != 1 || seen[3] != 1 {
+		t.Fatalf("OnFillDial indices = %v, want each of 0..3 once", seen)
+	}
+	if p.Len() != 4 || p.Stats().OpenConns != 4 {
+		t.Fatalf("Len = %d, OpenConns = %d; want 4", p.Len(), p.Stats().OpenConns)
+	}
+
+	strict := &flakyFactory{MockFactory: testutil.NewMockFactory()}
+	if _, err := NewChannelPool(&PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: strict, FillOrder: FillInterleaved}); err == nil {
+		t.Fatal("interleaved fill ignored a failed dial")
+	}
+	if strict.Closed() != strict.Created() {
+		t.Fatalf("failed interleaved fill left connections open: created %d, closed %d", strict.Created(), strict.Closed())
+	}
+}
+
+func TestValidationTTLSkipsPing(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, ValidationTTL: time.Hour})
+	for i := 0; i < 5; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = p.Put(conn)
+	}
+	if f.Pinged() != 0 {
+		t.Fatalf("pinged %d times within ValidationTTL", f.Pinged())
+	}
+
+	wrapConn := <-p.conns
+	wrapConn.lastValidated = time.Now().Add(-2 * time.Hour)
+	p.conns <- wrapConn
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Pinged() != 1 {
+		t.Fatalf("pinged %d times after ValidationTTL expired, want 1", f.Pinged())
+	}
+}
+
+func TestCheckIdleOnPut(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, IdleTimeout: time.Minute, CheckIdleOnPut: true})
+	stale, _ := p.Get()
+	fresh, _ := p.Get()
+	p.mu.Lock()
+	p.active[stale].t = time.Now().Add(-2 * time.Minute)
+	p.mu.Unlock()
+
+	_ = p.Put(stale)
+	_ = p.Put(fresh)
+	waitFor(t, "stale connection to close", func() bool { return f.Closed() == 1 })
+	if p.Len() != 1 || p.Stats().OpenConns != 1 {
+		t.Fatalf("Len = %d, OpenConns = %d; want only the fresh connection pooled", p.Len(), p.Stats().OpenConns)
+	}
+	if conn, _ := p.Get(); conn != fresh {
+		t.Fatal("the connection older than IdleTimeout was pooled")
+	}
+}
+
+func TestPingAfterIdle(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, ValidationTTL: time.Hour, PingAfterIdle: time.Minute})
+	conn, _ := p.Get()
+	_ = p.Put(conn)
+	if f.Pinged() != 0 {
+		t.Fatalf("pinged %d times for a recently returned connection", f.Pinged())
+	}
+
+	p.mu.Lock()
+	p.idleSnapshotLocked()[0].lastUsed = time.Now().Add(-2 * time.Minute)
+	p.mu.Unlock()
+	got, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Pinged() != 1 || got != conn {
+		t.Fatalf("pinged %d times, got %v; want the long-idle connection pinged once and reused", f.Pinged(), got)
+	}
+	_ = p.Put(got)
+
+	//Ping 失败才关闭
+	f.Break(conn.(*testutil.MockConn))
+	p.mu.Lock()
+	p.idleSnapshotLocked()[0].lastUsed = time.Now().Add(-2 * time.Minute)
+	p.mu.Unlock()
+	if got, _ := p.Get(); got == conn {
+		t.Fatal("Get reused a long-idle connection whose Ping failed")
+	}
+	waitFor(t, "broken connection to close", func() bool { return f.Closed() == 1 })
+}
+
+// batchFactory 实现 BatchCloser, 记录每一批关闭的连接
+type batchFactory struct {
+	*testutil.MockFactory
+	mu      sync.Mutex
+	batches [][]interface{}
+}
+
+func (f *batchFactory) CloseBatch(conns []interface{}) error {
+	f.mu.Lock()
+	f.batches = append(f.batches, conns)
+	f.mu.Unlock()
+	return nil
+}
+
+func TestReleaseClosesInBatches(t *testing.T) {
+	f := &batchFactory{MockFactory: testutil.NewMockFactory()}
+	p, err := NewChannelPool(&PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, Factory: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+	if len(f.batches) != 1 || len(f.batches[0]) != 3 || f.Closed() != 0 {
+		t.Fatalf("batches = %v, single closes = %d; want one batch of 3", f.batches, f.Closed())
+	}
+}
+
+func TestApplyDeadlineOnCheckout(t *testing.T) {
+	var deadlines []time.Time
+	p, _ := newTestPool(t, &PoolConfig{
+		InitialCap:    1,
+		MaxIdle:       1,
+		MaxCap:        2,
+		UseTimeout:    time.Minute,
+		ApplyDeadline: func(conn interface{}, deadline time.Time) { deadlines = append(deadlines, deadline) },
+	})
+	start := time.Now()
+	for i := 0; i < 2; i++ { //一次取空闲连接, 一次新建
+		if _, err := p.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(deadlines) != 2 {
+		t.Fatalf("ApplyDeadline called %d times, want 2", len(deadlines))
+	}
+	for _, deadline := range deadlines {
+		if d := deadline.Sub(start); d < time.Minute || d > time.Minute+time.Second {
+			t.Fatalf("deadline %s after Get, want about UseTimeout", d)
+		}
+	}
+}
+
+func TestSetDegraded(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 3, ServeIdleWhenDegraded: true})
+	p.SetDegraded(true)
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("Get of the idle conn while degraded = %v", err)
+	}
+	if _, err := p.Get(); err != ErrDegraded {
+		t.Fatalf("Get needing a dial while degraded = %v, want ErrDegraded", err)
+	}
+	if _, _, err := p.GetOrCreate(); err != ErrDegraded {
+		t.Fatalf("GetOrCreate while degraded = %v, want ErrDegraded", err)
+	}
+	p.SetDegraded(false)
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	strict, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 3})
+	strict.SetDegraded(true)
+	if _, err := strict.Get(); err != ErrDegraded || strict.Len() != 1 {
+		t.Fatalf("Get = %v, Len = %d; want ErrDegraded with the idle conn kept", err, strict.Len())
+	}
+}
+
+// getResult 后台 Get 的结果
+type getResult struct {
+	conn interface{}
+	err  error
+}
+
+// getAsync 在后台 Get, 等它进入等待队列后返回结果 channel
+func getAsync(t *testing.T, p *channelPool) <-chan getResult {
+	t.Helper()
+	waiters := p.Stats().Waiters
+	done := make(chan getResult, 1)
+	go func() {
+		conn, err := p.Get()
+		done <- getResult{conn, err}
+	}()
+	waitFor(t, "Get to queue", func() bool { return p.Stats().Waiters > waiters })
+	return done
+}
+
+func TestReleaseUnblocksWaiters(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, WaitTimeout: 5 * time.Second})
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	done := getAsync(t, p)
+	p.Release()
+	select {
+	case r := <-done:
+		if r.err != ErrClosed {
+			t.Fatalf("waiting Get = %v, want ErrClosed", r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Release did not wake the waiting Get")
+	}
+}
+
+func TestWaitingGetReceivesPutAndFreedSlot(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, WaitTimeout: 5 * time.Second})
+	conn, _ := p.Get()
+
+	done := getAsync(t, p)
+	_ = p.Put(conn)
+	if r := <-done; r.conn != conn {
+		t.Fatalf("waiter got %v, %v; want the connection that was put back", r.conn, r.err)
+	}
+
+	done = getAsync(t, p)
+	_ = p.Close(conn) //关闭空出的名额让等待者新建
+	if r := <-done; r.err != nil || r.conn == conn {
+		t.Fatalf("waiter got %v, %v; want a newly dialed connection", r.conn, r.err)
+	}
+}
+
+func TestWaitTimeoutExpires(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, WaitTimeout: 30 * time.Millisecond})
+	_, _ = p.Get()
+	start := time.Now()
+	if _, err := p.Get(); err != ErrMaxActiveConnReached {
+		t.Fatalf("Get = %v, want ErrMaxActiveConnReached", err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("Get gave up after %s, before WaitTimeout", d)
+	}
+	if p.Stats().Waiters != 0 {
+		t.Fatal("timed out waiter left in the queue")
+	}
+}
+
+func TestGetWithInfo(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	createdAt := time.Now().Add(-time.Hour)
+	wrapConn := <-p.conns
+	wrapConn.t = createdAt
+	p.conns <- wrapConn
+
+	conn, info, err := p.GetWithInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.UseCount != 1 || !info.CreatedAt.Equal(createdAt) || info.Age() < time.Hour {
+		t.Fatalf("first checkout info = %+v", info)
+	}
+	_ = p.Put(conn)
+	_, info, _ = p.GetWithInfo()
+	if info.UseCount != 2 || info.LastUsed.Before(createdAt.Add(time.Minute)) {
+		t.Fatalf("second checkout info = %+v", info)
+	}
+}
+
+func TestOnCreateRunsOncePerDial(t *testing.T) {
+	created := 0
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 2, MaxCap: 3, OnCreate: func(interface{}) error { created++; return nil }})
+	conn, _ := p.Get()
+	_ = p.Put(conn)
+	_, _ = p.Get() //复用, 不再执行 OnCreate
+	_, _ = p.Get() //新建
+	if created != 2 {
+		t.Fatalf("OnCreate ran %d times, want 2", created)
+	}
+
+	errAuth := errors.New("auth failed")
+	failing, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, OnCreate: func(interface{}) error { return errAuth }})
+	if _, err := failing.Get(); err != errAuth {
+		t.Fatalf("Get = %v, want the OnCreate error", err)
+	}
+	if failing.Stats().OpenConns != 0 || f.Closed() != 1 {
+		t.Fatalf("OpenConns = %d, closed %d; want the rejected conn closed and its slot returned", failing.Stats().OpenConns, f.Closed())
+	}
+}
+
+func TestSetFactoryKeepsOwnerFactory(t *testing.T) {
+	oldFactory, newFactory := testutil.NewMockFactory(), testutil.NewMockFactory()
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 3, MaxCap: 5, Factory: oldFactory})
+	a, _ := p.Get()
+	p.SetFactory(newFactory)
+	b, _ := p.Get()
+	if newFactory.Created() != 1 {
+		t.Fatalf("new factory dialed %d times after SetFactory, want 1", newFactory.Created())
+	}
+
+	_ = p.Close(a) //旧连接仍由旧工厂关闭
+	if oldFactory.Closed() != 1 || newFactory.Closed() != 0 {
+		t.Fatalf("closed by old %d, new %d; want 1 and 0", oldFactory.Closed(), newFactory.Closed())
+	}
+	_ = p.Put(b)
+	p.Release()
+	if newFactory.Closed() != 1 || oldFactory.Closed() != 1 {
+		t.Fatalf("Release closed by old %d, new %d", oldFactory.Closed(), newFactory.Closed())
+	}
+}
+
+func TestValidateOnPutReplacement(t *testing.T) {
+	f := testutil.NewMockFactory()
+	var replacement interface{}
+	p, _ := newTestPool(t, &PoolConfig{
+		MaxIdle:       2,
+		MaxCap:        2,
+		Factory:       f,
+		ValidateOnPut: true,
+		OnPutValidationFail: func(interface{}) (interface{}, error) {
+			var err error
+			replacement, err = f.Factory()
+			return replacement, err
+		},
+	})
+	conn, _ := p.Get()
+	f.Break(conn.(*testutil.MockConn))
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 1 || p.Stats().OpenConns != 1 || f.Closed() != 1 {
+		t.Fatalf("Len = %d, OpenConns = %d, closed %d", p.Len(), p.Stats().OpenConns, f.Closed())
+	}
+	if next, _ := p.Get(); next != replacement {
+		t.Fatal("Get did not return the replacement connection")
+	}
+}
+
+func TestValidateOnPutDropsBroken(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2, ValidateOnPut: true})
+	conn, _ := p.Get()
+	f.Break(conn.(*testutil.MockConn))
+	_ = p.Put(conn)
+	if p.Len() != 0 || p.Stats().OpenConns != 0 || f.Closed() != 1 {
+		t.Fatalf("Len = %d, OpenConns = %d, closed %d", p.Len(), p.Stats().OpenConns, f.Closed())
+	}
+}
+
+func TestReleaseWithHook(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3})
+	var seen []int
+	p.ReleaseWithHook(func(conn interface{}) {
+		if f.Closed() != 0 {
+			t.Error("hook ran after a connection was already closed")
+		}
+		seen = append(seen, conn.(*testutil.MockConn).ID)
+	})
+	if len(seen) != 3 || f.Closed() != 3 {
+		t.Fatalf("hook saw %v, closed %d; want all 3", seen, f.Closed())
+	}
+}
+
+func TestOnGetDiscardsAreBounded(t *testing.T) {
+	var p *channelPool
+	failures := 0
+	p, _ = newTestPool(t, &PoolConfig{
+		InitialCap: 2,
+		MaxIdle:    2,
+		MaxCap:     5,
+		OnGet: func(conn interface{}) error {
+			if conn.(*testutil.MockConn).ID <= 2 || conn.(*testutil.MockConn).ID >= 1000 {
+				failures++
+				//每丢弃一条就有一条同样坏的放回来, 空闲缓冲永远不空
+				_ = p.Put(&testutil.MockConn{ID: 1000 + failures})
+				return errors.New("setup failed")
+			}
+			return nil
+		},
+	})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := conn.(*testutil.MockConn).ID; id != 3 {
+		t.Fatalf("Get returned conn %d, want a newly dialed conn", id)
+	}
+	if failures != 2 {
+		t.Fatalf("Get ran OnGet on %d idle connections, want at most MaxIdle (2)", failures)
+	}
+}
+
+// tracedConn Decorate 包装后的连接
+type tracedConn struct {
+	raw interface{}
+}
+
+func TestDecorateWrapsConnections(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{
+		InitialCap: 1,
+		MaxIdle:    1,
+		MaxCap:     2,
+		Decorate:   func(conn interface{}) (interface{}, error) { return &tracedConn{raw: conn}, nil },
+	})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*tracedConn); !ok {
+		t.Fatalf("Get returned %T, want *tracedConn", conn)
+	}
+	if err := p.Close(conn); err != nil || f.Closed() != 1 {
+		t.Fatalf("Close = %v, closed %d", err, f.Closed())
+	}
+
+	errWrap := errors.New("tls handshake failed")
+	failing, failingFactory := newTestPool(t, &PoolConfig{
+		MaxIdle:  1,
+		MaxCap:   1,
+		Decorate: func(interface{}) (interface{}, error) { return nil, errWrap },
+	})
+	if _, err := failing.Get(); err != errWrap {
+		t.Fatalf("Get = %v, want the Decorate error", err)
+	}
+	if failingFactory.Closed() != 1 || failing.Stats().OpenConns != 0 {
+		t.Fatalf("raw conn not closed after Decorate failure: closed %d, OpenConns = %d", failingFactory.Closed(), failing.Stats().OpenConns)
+	}
+}
+
+func TestReadyAfterAsyncFill(t *testing.T) {
+	f := testutil.NewMockFactory()
+	f.SetDialDelay(20 * time.Millisecond)
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, Factory: f, AsyncFill: true})
+	select {
+	case <-p.Ready():
+		t.Fatal("Ready closed before the async fill finished")
+	default:
+	}
+	<-p.Ready()
+	if p.Len() != 3 || p.Stats().OpenConns != 3 {
+		t.Fatalf("Len = %d, OpenConns = %d after Ready", p.Len(), p.Stats().OpenConns)
+	}
+
+	filled, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	select {
+	case <-filled.Ready():
+	default:
+		t.Fatal("Ready not closed after a synchronous fill")
+	}
+}
+
+func TestReleaseDuringAsyncFill(t *testing.T) {
+	f := testutil.NewMockFactory()
+	f.SetDialDelay(20 * time.Millisecond)
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, Factory: f, AsyncFill: true})
+	waitFor(t, "the first dial", func() bool { return f.Created() == 1 })
+	p.Release()
+	<-p.Ready()
+	if p.Stats().OpenConns != 0 || f.Closed() != f.Created() {
+		t.Fatalf("OpenConns = %d, created %d, closed %d", p.Stats().OpenConns, f.Created(), f.Closed())
+	}
+}
+
+func TestMaxConnLifetimeJitter(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 20, MaxIdle: 20, MaxCap: 20, MaxConnLifetime: time.Second, MaxConnLifetimeJitter: time.Second})
+	p.mu.Lock()
+	snapshot := p.idleSnapshotLocked()
+	p.mu.Unlock()
+	shortest, longest := time.Hour, time.Duration(0)
+	for _, wrapConn := range snapshot {
+		if wrapConn.lifetime < time.Second || wrapConn.lifetime >= 2*time.Second {
+			t.Fatalf("lifetime %s outside [1s, 2s)", wrapConn.lifetime)
+		}
+		shortest, longest = min(shortest, wrapConn.lifetime), max(longest, wrapConn.lifetime)
+	}
+	if longest-shortest < 100*time.Millisecond {
+		t.Fatalf("lifetimes %s..%s are not spread out", shortest, longest)
+	}
+}
+
+func TestMaxConnLifetimeExpiresOnGet(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2, MaxConnLifetime: time.Minute})
+	wrapConn := <-p.conns
+	wrapConn.t = time.Now().Add(-2 * time.Minute)
+	p.conns <- wrapConn
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == wrapConn.conn || f.Created() != 2 {
+		t.Fatal("Get returned a connection past MaxConnLifetime")
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	if err := p.WaitReady(context.Background()); err != nil {
+		t.Fatalf("WaitReady with room to dial = %v", err)
+	}
+	conn, _ := p.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.WaitReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitReady at MaxCap = %v, want DeadlineExceeded", err)
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = p.Put(conn) })
+	if err := p.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 1 {
+		t.Fatal("WaitReady checked out the connection")
+	}
+
+	_, _ = p.Get()
+	time.AfterFunc(20*time.Millisecond, p.Release)
+	if err := p.WaitReady(context.Background()); err != ErrClosed {
+		t.Fatalf("WaitReady across Release = %v, want ErrClosed", err)
+	}
+}
+
+func TestReleaseDuringGetDiscard(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		p, _ := newTestPool(t, &PoolConfig{InitialCap: 5, MaxIdle: 5, MaxCap: 5, OnGet: func(interface{}) error { return errors.New("setup failed") }})
+		errs := make(chan error, 1)
+		go func() {
+			_, err := p.Get()
+			errs <- err
+		}()
+		p.Release()
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Fatal("Get succeeded although every OnGet fails")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Get kept looping after Release")
+		}
+	}
+}
+
+func TestAsyncResetOnPut(t *testing.T) {
+	release := make(chan struct{})
+	p, _ := newTestPool(t, &PoolConfig{
+		InitialCap: 1,
+		MaxIdle:    2,
+		MaxCap:     2,
+		ResetOnPut: func(interface{}) error { <-release; return nil },
+		AsyncReset: true,
+	})
+	conn, _ := p.Get()
+	start := time.Now()
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("Put waited %s for the reset", d)
+	}
+	if p.Len() != 0 {
+		t.Fatal("connection was buffered before its reset finished")
+	}
+	close(release)
+	waitFor(t, "reset connection to be buffered", func() bool { return p.Len() == 1 })
+}
+
+func TestResetOnPutFailureCloses(t *testing.T) {
+	errReset := errors.New("rollback failed")
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, ResetOnPut: func(interface{}) error { return errReset }})
+	conn, _ := p.Get()
+	if err := p.Put(conn); err != errReset {
+		t.Fatalf("Put = %v, want the ResetOnPut error", err)
+	}
+	if p.Len() != 0 || f.Closed() != 1 || p.Stats().OpenConns != 0 {
+		t.Fatalf("Len = %d, closed %d, OpenConns = %d", p.Len(), f.Closed(), p.Stats().OpenConns)
+	}
+}
+
+func TestFailWaiters(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, WaitTimeout: 5 * time.Second})
+	_, _ = p.Get()
+	var pending []<-chan getResult
+	for i := 0; i < 3; i++ {
+		pending = append(pending, getAsync(t, p))
+	}
+	errShutdown := errors.New("shutting down")
+	if n := p.FailWaiters(errShutdown); n != 3 {
+		t.Fatalf("FailWaiters = %d, want 3", n)
+	}
+	for _, done := range pending {
+		if r := <-done; r.err != errShutdown {
+			t.Fatalf("waiter got %v, want the FailWaiters error", r.err)
+		}
+	}
+	if p.Stats().Waiters != 0 {
+		t.Fatal("waiters left in the queue")
+	}
+}
+
+func TestOnSlowGet(t *testing.T) {
+	f := testutil.NewMockFactory()
+	var slow []time.Duration
+	p, _ := newTestPool(t, &PoolConfig{
+		MaxIdle:          2,
+		MaxCap:           2,
+		Factory:          f,
+		SlowGetThreshold: 10 * time.Millisecond,
+		OnSlowGet:        func(d time.Duration) { slow = append(slow, d) },
+	})
+	f.SetDialDelay(30 * time.Millisecond)
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slow) != 1 || slow[0] < 30*time.Millisecond {
+		t.Fatalf("OnSlowGet calls = %v, want one of at least 30ms", slow)
+	}
+	_ = p.Put(conn)
+	if _, err := p.Get(); err != nil { //取空闲连接很快, 不报告
+		t.Fatal(err)
+	}
+	if len(slow) != 1 {
+		t.Fatalf("OnSlowGet called for a fast Get: %v", slow)
+	}
+}
+
+func TestGetWaitsForInUseWhenIdleBadAndDialFails(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, WaitTimeout: time.Second})
+	inUse, _ := p.Get()
+	p.mu.Lock()
+	idle := p.idleSnapshotLocked()[0].conn
+	p.mu.Unlock()
+	f.Break(idle.(*testutil.MockConn))
+	f.FailFactory(errors.New("connection refused"))
+
+	time.AfterFunc(30*time.Millisecond, func() { _ = p.Put(inUse) })
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get = %v, want the in-use connection once it is put back", err)
+	}
+	if conn != inUse {
+		t.Fatal("Get returned a connection other than the one put back")
+	}
+}
+
+func TestGetReportsDialErrorWhenNothingReturns(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, WaitTimeout: 30 * time.Millisecond})
+	_, _ = p.Get()
+	p.mu.Lock()
+	idle := p.idleSnapshotLocked()[0].conn
+	p.mu.Unlock()
+	f.Break(idle.(*testutil.MockConn))
+	errDial := errors.New("connection refused")
+	f.FailFactory(errDial)
+
+	if _, err := p.Get(); err != errDial {
+		t.Fatalf("Get = %v, want the dial error after WaitTimeout", err)
+	}
+	if p.Stats().Waiters != 0 {
+		t.Fatal("timed out waiter left in the queue")
+	}
+}
+
+func TestProbeFactory(t *testing.T) {
+	f := testutil.NewMockFactory()
+	f.FailFactory(errors.New("dial tcp 10.0.0.1:6379: connection refused"))
+	for _, poolConfig := range []*PoolConfig{
+		{InitialCap: 2, MaxIdle: 2, MaxCap: 2, Factory: f, ProbeFactory: true},
+		{InitialCap: 2, MaxIdle: 2, MaxCap: 2, Factory: f, ProbeFactory: true, BestEffortFill: true, AsyncFill: true},
+	} {
+		_, err := NewChannelPool(poolConfig)
+		if err == nil {
+			t.Fatal("NewChannelPool succeeded with a failing probe")
+		}
+		if msg := err.Error(); !strings.Contains(msg, "*testutil.MockFactory") || !strings.Contains(msg, "connection refused") {
+			t.Fatalf("probe error %q does not name the factory and cause", msg)
+		}
+	}
+
+	p, probed := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, ProbeFactory: true})
+	if probed.Created() != 3 || p.Len() != 3 {
+		t.Fatalf("created %d, Len = %d; the probe should count as the first initial conn", probed.Created(), p.Len())
+	}
+	empty, emptyFactory := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, ProbeFactory: true})
+	if emptyFactory.Created() != 1 || empty.Len() != 1 || empty.Stats().OpenConns != 1 {
+		t.Fatalf("created %d, Len = %d, OpenConns = %d; want the probe kept idle", emptyFactory.Created(), empty.Len(), empty.Stats().OpenConns)
+	}
+}
+
+func TestEvictOldestOnPut(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 3, EvictOldestOnPut: true})
+	var conns []interface{}
+	for i := 0; i < 3; i++ {
+		conn, _ := p.Get()
+		conns = append(conns, conn)
+	}
+	p.mu.Lock()
+	p.active[conns[0]].t = time.Now().Add(-2 * time.Hour)
+	p.active[conns[1]].t = time.Now().Add(-time.Hour)
+	p.mu.Unlock()
+	_ = p.Put(conns[0])
+	_ = p.Put(conns[1])
+
+	if err := p.Put(conns[2]); err != nil { //缓冲已满, 换下最旧的 conns[0]
+		t.Fatal(err)
+	}
+	if f.Closed() != 1 || p.Len() != 2 {
+		t.Fatalf("closed %d, Len = %d; want 1 and 2", f.Closed(), p.Len())
+	}
+	p.mu.Lock()
+	for _, wrapConn := range p.idleSnapshotLocked() {
+		if wrapConn.conn == conns[0] {
+			t.Error("the oldest idle connection was kept")
+		}
+	}
+	p.mu.Unlock()
+	if p.Stats().PutDiscardedFull != 1 {
+		t.Fatalf("PutDiscardedFull = %d, want 1", p.Stats().PutDiscardedFull)
+	}
+}
+
+func TestEvictOldestOnPutClosesOlderReturn(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 2, EvictOldestOnPut: true})
+	older, _ := p.Get()
+	younger, _ := p.Get()
+	p.mu.Lock()
+	p.active[older].t = time.Now().Add(-time.Hour)
+	p.mu.Unlock()
+	_ = p.Put(younger)
+	_ = p.Put(older) //放回的比空闲的更旧, 关闭它自己
+	if f.Closed() != 1 || p.Len() != 1 {
+		t.Fatalf("closed %d, Len = %d; want 1 and 1", f.Closed(), p.Len())
+	}
+	if next, _ := p.Get(); next != younger {
+		t.Fatal("the older returned connection replaced a younger idle one")
+	}
+}
+
+// identFactory 实现 Identifier, 连接标识为 mock-<ID>
+type identFactory struct {
+	*testutil.MockFactory
+}
+
+func (identFactory) ID(conn interface{}) string {
+	return fmt.Sprintf("mock-%d", conn.(*testutil.MockConn).ID)
+}
+
+func TestIdentifierInLogsAndInfo(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	f := identFactory{testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2, Factory: f})
+	f.Break(&testutil.MockConn{ID: 1})
+	_, info, err := p.GetWithInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "discarding connection mock-1: ping failed") {
+		t.Fatalf("discard log missing the connection ID: %q", logs.String())
+	}
+	if info.ID != "mock-2" {
+		t.Fatalf("ConnInfo.ID = %q, want mock-2", info.ID)
+	}
+}
+
+// liveFactory 统计同时存在的连接数(拨号中的也算), 记录最大值
+type liveFactory struct {
+	*testutil.MockFactory
+	live, peak atomic.Int32
+	dials      atomic.Int32
+}
+
+func (f *liveFactory) Factory() (interface{}, error) {
+	if n := f.live.Add(1); n > f.peak.Load() {
+		for peak := f.peak.Load(); n > peak && !f.peak.CompareAndSwap(peak, n); peak = f.peak.Load() {
+		}
+	}
+	if f.dials.Add(1)%2 == 1 { //一半的拨号很慢, 触发对冲
+		time.Sleep(20 * time.Millisecond)
+	}
+	return f.MockFactory.Factory()
+}
+
+func (f *liveFactory) Close(conn interface{}) error {
+	f.live.Add(-1)
+	return f.MockFactory.Close(conn)
+}
+
+func TestNoOvershootUnderConcurrency(t *testing.T) {
+	f := &liveFactory{MockFactory: testutil.NewMockFactory()}
+	//MaxIdle 等于 MaxCap, 放回时不会关闭连接, 只有对冲落败的连接会被关闭
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 5, MaxCap: 5, Factory: f, HedgeDelay: 2 * time.Millisecond, WaitTimeout: time.Second})
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 20; j++ {
+				conn, err := p.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+				_ = p.Put(conn)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if peak := f.peak.Load(); peak > 5 {
+		t.Fatalf("%d connections existed at once, MaxCap is 5", peak)
+	}
+	waitFor(t, "hedge slots to be returned", func() bool { return p.Stats().OpenConns == int(f.live.Load()) })
+}
+
+func TestUnhashableConnRejected(t *testing.T) {
+	f := &sliceFactory{}
+	if _, err := NewChannelPool(&PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, Factory: f}); err == nil {
+		t.Fatal("NewChannelPool accepted a factory returning unhashable connections")
+	}
+
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f})
+	if _, err := p.Get(); err != ErrUnhashableConn {
+		t.Fatalf("Get = %v, want ErrUnhashableConn", err)
+	}
+	if got := p.Stats().OpenConns; got != 0 {
+		t.Fatalf("OpenConns = %d after rejected dial, want 0", got)
+	}
+	if err := p.Put(sliceConn{}); err != ErrUnhashableConn {
+		t.Fatalf("Put = %v, want ErrUnhashableConn", err)
+	}
+	if err := p.Close(sliceConn{}); err != ErrUnhashableConn {
+		t.Fatalf("Close = %v, want ErrUnhashableConn", err)
+	}
+}
+
+func TestCloseTwiceClosesOnce(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- p.Close(conn) }()
+	}
+	first, second := <-errs, <-errs
+	if !(first == nil && second == ErrAlreadyClosed) && !(first == ErrAlreadyClosed && second == nil) {
+		t.Fatalf("Close results = %v, %v; want one nil and one ErrAlreadyClosed", first, second)
+	}
+	if f.Closed() != 1 || p.Stats().OpenConns != 0 {
+		t.Fatalf("closed %d times, OpenConns = %d", f.Closed(), p.Stats().OpenConns)
+	}
+}
+
+func TestPutAfterCloseRejected(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2})
+	conn, _ := p.Get()
+	if err := p.Close(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(conn); err != ErrAlreadyClosed {
+		t.Fatalf("Put after Close = %v, want ErrAlreadyClosed", err)
+	}
+	if p.Len() != 0 {
+		t.Fatal("closed connection was buffered")
+	}
+	next, err := p.Get()
+	if err != nil || next == conn {
+		t.Fatalf("Get = %v, %v; want a new connection", next, err)
+	}
+}
+
+// recyclingFactory 关闭的连接对象放回空闲链表, 下次 Factory 复用
+type recyclingFactory struct {
+	free   []*int
+	closed int
+}
+
+func (f *recyclingFactory) Factory() (interface{}, error) {
+	if n := len(f.free); n > 0 {
+		conn := f.free[n-1]
+		f.free = f.free[:n-1]
+		return conn, nil
+	}
+	return new(int), nil
+}
+
+func (f *recyclingFactory) Close(conn interface{}) error {
+	f.closed++
+	f.free = append(f.free, conn.(*int))
+	return nil
+}
+
+func (f *recyclingFactory) Ping(interface{}) error { return nil }
+
+func TestRecycledConnCanBeClosedAgain(t *testing.T) {
+	f := &recyclingFactory{}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f})
+	for i := 0; i < 3; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		if err := p.Close(conn); err != nil {
+			t.Fatalf("round %d: Close = %v", i, err)
+		}
+	}
+	if f.closed != 3 || p.Stats().OpenConns != 0 {
+		t.Fatalf("closed %d times, OpenConns = %d", f.closed, p.Stats().OpenConns)
+	}
+}
+
+func TestGetFreshDialsAtMaxCap(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, CloseLinger: time.Hour})
+	old := <-p.conns
+	old.t = time.Now().Add(-2 * time.Second)
+	p.conns <- old
+
+	conn, err := p.GetFresh(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == old.conn {
+		t.Fatal("GetFresh returned the stale connection")
+	}
+	if f.Created() != 2 || f.Closed() != 1 {
+		t.Fatalf("created %d, closed %d; want 2 and 1", f.Created(), f.Closed())
+	}
+}
+
+func TestGetOrCreateNeverBlocks(t *testing.T) {
+	gets := 0
+	p, _ := newTestPool(t, &PoolConfig{
+		MaxIdle:     1,
+		MaxCap:      1,
+		WaitTimeout: time.Second,
+		OnGet:       func(interface{}) error { gets++; return nil },
+	})
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	conn, overCap, err := p.GetOrCreate()
+	if err != nil || !overCap {
+		t.Fatalf("GetOrCreate = %v, %v, %v", conn, overCap, err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("GetOrCreate blocked for %s", d)
+	}
+	if gets != 2 {
+		t.Fatalf("OnGet ran %d times, want 2", gets)
+	}
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if p.Stats().OpenConns != 1 {
+		t.Fatalf("over-cap connection was kept: OpenConns = %d", p.Stats().OpenConns)
+	}
+}
+
+func TestMinDialIntervalSpacesGetDials(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 5, MaxCap: 5, MinDialInterval: 30 * time.Millisecond})
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != ErrDialRateLimited {
+		t.Fatalf("Get inside the interval = %v, want ErrDialRateLimited", err)
+	}
+	if _, _, err := p.GetOrCreate(); err != ErrDialRateLimited {
+		t.Fatalf("GetOrCreate inside the interval = %v, want ErrDialRateLimited", err)
+	}
+
+	p.waitTimeOut = time.Second
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := p.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("two dials took %s, want at least two intervals", d)
+	}
+}
+
+func TestMinDialIntervalAppliesToBackgroundDials(t *testing.T) {
+	start := time.Now()
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, MinDialInterval: 20 * time.Millisecond})
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("initial fill of 3 took %s, want at least two intervals", d)
+	}
+
+	start = time.Now()
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("Flush of 3 took %s, want its dials spaced by the interval", d)
+	}
+	if f.Created() != 6 {
+		t.Fatalf("created %d, want 6", f.Created())
+	}
+}
+
+func BenchmarkGetPut(b *testing.B) {
+	p, _ := newTestPool(b, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := p.Put(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// selfCheckFactory 连接的健康状态由测试直接标记, 模拟协议层记录的错误
+type selfCheckFactory struct {
+	*testutil.MockFactory
+	unhealthy sync.Map
+}
+
+func (f *selfCheckFactory) Healthy(conn interface{}) bool {
+	_, bad := f.unhealthy.Load(conn)
+	return !bad
+}
+
+func TestSelfCheckerOnPut(t *testing.T) {
+	f := &selfCheckFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2, Factory: f, ValidateOnPut: true})
+	good, _ := p.Get()
+	bad, _ := p.Get()
+	f.unhealthy.Store(bad, true)
+	if err := p.Put(bad); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(good); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "unhealthy connection closed", func() bool { return f.Closed() == 1 })
+	if p.Len() != 1 || p.Stats().OpenConns != 1 {
+		t.Fatalf("idle=%d open=%d, want only the healthy connection kept", p.Len(), p.Stats().OpenConns)
+	}
+	// 只有健康的那条做了 ValidateOnPut 的 Ping
+	if f.Pinged() != 1 {
+		t.Fatalf("pinged %d times, want 1", f.Pinged())
+	}
+}
+
+func TestPutWaitForSpace(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 2, PutWait: time.Second})
+	a, _ := p.Get()
+	b, _ := p.Get()
+	_ = p.Put(a) //空闲缓冲已满
+
+	done := make(chan error, 1)
+	go func() { done <- p.Put(b) }()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Put returned while the idle buffer was full")
+	default:
+	}
+	got, err := p.Get() //取走 a, 空出位置
+	if err != nil || got != a {
+		t.Fatalf("Get = %v, %v; want the idle connection", got, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if f.Closed() != 0 || p.Len() != 1 || p.Stats().PutDiscardedFull != 0 {
+		t.Fatalf("closed %d, Len = %d, PutDiscardedFull = %d; want b pooled instead of closed",
+			f.Closed(), p.Len(), p.Stats().PutDiscardedFull)
+	}
+}
+
+func TestPutWaitSkippedWithEvictOldest(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 2, PutWait: time.Second, EvictOldestOnPut: true})
+	a, _ := p.Get()
+	b, _ := p.Get()
+	_ = p.Put(a)
+	start := time.Now()
+	_ = p.Put(b) //空闲缓冲已满, 换下最旧的 a, 不必等待
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Fatalf("Put waited %v although EvictOldestOnPut never closes the returned connection", elapsed)
+	}
+	waitFor(t, "oldest idle connection evicted", func() bool { return f.Closed() == 1 })
+}
+
+func TestPutDelayUsesClockAndRelease(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2, PutDelay: time.Minute})
+	clock := &fakeClock{}
+	p.afterFunc = clock.AfterFunc
+	a, _ := p.Get()
+	b, _ := p.Get()
+	_ = p.Put(a)
+	clock.Advance(time.Minute)
+	if p.Len() != 1 {
+		t.Fatalf("idle=%d after the fake clock passed PutDelay, want a returned", p.Len())
+	}
+
+	_ = p.Put(b)
+	p.Release() //b 还在搁置中, 立即关闭
+	if f.Closed() != 2 {
+		t.Fatalf("closed %d after Release, want the held-back connection closed too", f.Closed())
+	}
+	clock.Advance(time.Minute) //到期的回调不再处理已关闭的连接
+	if f.Closed() != 2 {
+		t.Fatalf("closed %d, want the timer to skip the connection Release closed", f.Closed())
+	}
+}
+
+func TestPutDelay(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, PutDelay: 50 * time.Millisecond})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("idle=%d right after Put, want the connection held back", p.Len())
+	}
+	if _, err := p.Get(); err != ErrMaxActiveConnReached {
+		t.Fatalf("Get during PutDelay = %v, want ErrMaxActiveConnReached", err)
+	}
+	waitFor(t, "delayed return", func() bool { return p.Len() == 1 })
+	got, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != conn || f.Created() != 1 {
+		t.Fatalf("got %v (created %d), want the delayed connection reused", got, f.Created())
+	}
+}
+
+func TestZeroMaxIdleNoPoolMode(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 0, MaxCap: 2, WaitTimeout: time.Second})
+	first, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 仍然受 MaxCap 限制, 放回的连接直接交给等待者
+	done := getAsync(t, p)
+	if err := p.Put(first); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-done; r.err != nil || r.conn != first {
+		t.Fatalf("waiter got %v, %v; want the returned connection", r.conn, r.err)
+	}
+	// 没有等待者时放回即关闭, 不进入空闲缓冲
+	if err := p.Put(second); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 0 || f.Closed() != 1 || p.Stats().PutDiscardedFull != 0 {
+		t.Fatalf("idle=%d closed=%d discardedFull=%d, want 0, 1, 0", p.Len(), f.Closed(), p.Stats().PutDiscardedFull)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Created() != 3 {
+		t.Fatalf("created=%d, want a fresh dial after the close", f.Created())
+	}
+}
+
+func TestRefillOnPingFail(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 3, RefillOnPingFail: true})
+	p.mu.Lock()
+	idle := p.idleSnapshotLocked()
+	p.mu.Unlock()
+	f.Break(idle[0].conn.(*testutil.MockConn))
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != idle[1].conn {
+		t.Fatalf("Get = %v, want the second idle connection", conn)
+	}
+	// 坏连接在后台被补上, 热连接数回到 InitialCap
+	waitFor(t, "background refill", func() bool { return p.Len() == 1 && f.Created() == 3 })
+	if open := p.Stats().OpenConns; open != 2 {
+		t.Fatalf("open=%d, want InitialCap 2", open)
+	}
+}
+
+func TestGetLongestLived(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, MaxConnLifetime: time.Hour})
+	now := time.Now()
+	p.mu.Lock()
+	idle := p.idleSnapshotLocked()
+	idle[0].t = now.Add(-30 * time.Minute)
+	idle[1].t = now.Add(-time.Minute) //最年轻, 剩余存活时间最长
+	idle[2].t = now.Add(-10 * time.Minute)
+	p.mu.Unlock()
+
+	conn, err := p.GetLongestLived()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != idle[1].conn {
+		t.Fatalf("GetLongestLived = %v, want the youngest connection %v", conn, idle[1].conn)
+	}
+	if p.Len() != 2 {
+		t.Fatalf("idle=%d, want the other two left idle", p.Len())
+	}
+
+	// 存活时间带抖动时比较的是剩余时间而不是创建时刻
+	p.mu.Lock()
+	idle[0].lifetime = 2 * time.Hour //剩余 90m
+	p.mu.Unlock()
+	if conn, _ := p.GetLongestLived(); conn != idle[0].conn {
+		t.Fatalf("GetLongestLived = %v, want the connection with the most remaining lifetime", conn)
+	}
+}
+
+func TestGetLongestLivedRespectsDegraded(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	p.SetDegraded(true)
+	if _, err := p.GetLongestLived(); err != ErrDegraded {
+		t.Fatalf("GetLongestLived = %v while degraded, want ErrDegraded", err)
+	}
+}
+
+// intermittentFactory failing 为 true 时 Ping 失败, 模拟时好时坏的连接
+type intermittentFactory struct {
+	*testutil.MockFactory
+	failing atomic.Bool
+}
+
+func (f *intermittentFactory) Ping(conn interface{}) error {
+	if f.failing.Load() {
+		return errors.New("i/o timeout")
+	}
+	return f.MockFactory.Ping(conn)
+}
+
+func TestMaxPingFailuresRetiresFlakyConn(t *testing.T) {
+	f := &intermittentFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, Factory: f, MaxPingFailures: 2})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := p.Ping(conn); err == nil {
+			t.Fatal("Ping succeeded while the connection is failing")
+		}
+	}
+	f.failing.Store(false)
+	if err := p.Ping(conn); err != nil {
+		t.Fatalf("Ping = %v after recovery", err)
+	}
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "flaky connection to close", func() bool { return f.Closed() == 1 })
+	if p.Len() != 0 || p.Stats().OpenConns != 0 {
+		t.Fatalf("Len = %d, OpenConns = %d; want the flaky connection retired", p.Len(), p.Stats().OpenConns)
+	}
+}
+
+func TestPingFailureWindowResetsCount(t *testing.T) {
+	f := &intermittentFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, Factory: f,
+		MaxPingFailures: 2, PingFailureWindow: 50 * time.Millisecond})
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.failing.Store(true)
+	_ = p.Ping(conn)
+	_ = p.Ping(conn)
+	time.Sleep(60 * time.Millisecond) //窗口过期, 重新计数
+	_ = p.Ping(conn)
+	f.failing.Store(false)
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 1 || f.Closed() != 0 {
+		t.Fatalf("Len = %d, closed %d; failures from an expired window were counted", p.Len(), f.Closed())
+	}
+}
+
+func TestBalanceWindowEvensUseCounts(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, BalanceWindow: 4})
+	p.mu.Lock()
+	idle := p.idleSnapshotLocked()
+	idle[0].useCount = 50 //这条已经被用得很多
+	p.mu.Unlock()
+
+	for i := 0; i < 150; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Put(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	minUse, maxUse := -1, 0
+	for _, wrapConn := range p.idleSnapshotLocked() {
+		if minUse < 0 || wrapConn.useCount < minUse {
+			minUse = wrapConn.useCount
+		}
+		maxUse = max(maxUse, wrapConn.useCount)
+	}
+	if maxUse-minUse > 1 {
+		t.Fatalf("use counts range from %d to %d after balanced Get/Put, want within 1", minUse, maxUse)
+	}
+}
+
+func TestMaxDiscardRateCapsChurn(t *testing.T) {
+	f := &intermittentFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: f, MaxDiscardRate: 2})
+	f.failing.Store(true) //后端抖动, 所有 Ping 都失败
+
+	start := time.Now()
+	for time.Since(start) < 300*time.Millisecond {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = p.Put(conn)
+	}
+	elapsed := time.Since(start)
+	f.failing.Store(false)
+	time.Sleep(20 * time.Millisecond) //丢弃的连接在后台关闭
+	discarded := f.Closed()
+	// 桶里一开始有 2 个令牌, 之后每秒补 2 个
+	if limit := 2 + int(elapsed.Seconds()*2) + 1; discarded > limit {
+		t.Fatalf("discarded %d connections in %v, want at most %d", discarded, elapsed, limit)
+	}
+	if discarded == 0 {
+		t.Fatal("no connection was discarded, the failing Pings were not checked at all")
+	}
+}
+
+func TestMaxDiscardRateStillDropsExpired(t *testing.T) {
+	f := &intermittentFactory{MockFactory: testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2, Factory: f, MaxDiscardRate: 1, IdleTimeout: 40 * time.Millisecond})
+	f.failing.Store(true)
+	stale, err := p.Get() //第一条 Ping 失败被丢弃, 用掉唯一的令牌; 第二条不再检查, 直接借出
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.failing.Store(false)
+	_ = p.Put(stale)
+	time.Sleep(60 * time.Millisecond) //令牌还没补回, stale 已超过 IdleTimeout
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == stale {
+		t.Fatal("Get handed out an expired connection because the discard budget was empty")
+	}
+	_ = p.Put(conn)
+}
@@ -0,0 +1,81 @@
+package mypool
+
+import "time"
+
+// GetOutcome 一次取连接的结果, 传给 ObserveGet
+type GetOutcome int
+
+const (
+	// GetHit 取自空闲连接(包括备用连接和等待中拿到的放回连接)
+	GetHit GetOutcome = iota
+	// GetDial 新建了连接
+	GetDial
+	// GetMiss 没有取到连接, 返回了错误
+	GetMiss
+)
+
+func (o GetOutcome) String() string {
+	switch o {
+	case GetHit:
+		return "hit"
+	case GetDial:
+		return "dial"
+	case GetMiss:
+		return "miss"
+	}
+	return "unknown"
+}
+
+// PutOutcome 一次 Put 的结果, 传给 ObservePut
+type PutOutcome int
+
+const (
+	// PutPooled 连接放回了连接池(放入空闲缓冲、交给等待者或搁置后再放回)
+	PutPooled PutOutcome = iota
+	// PutClosed 连接被关闭
+	PutClosed
+)
+
+func (o PutOutcome) String() string {
+	switch o {
+	case PutPooled:
+		return "pooled"
+	case PutClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// observeGetting 配置了 ObserveGet 时给 get 计时并报告结果, 用于 Get 以外的取连接方法
+func (c *channelPool) observeGetting(get func() (interface{}, error)) (interface{}, error) {
+	if c.observeGet == nil {
+		return get()
+	}
+	start := time.Now()
+	conn, err := get()
+	c.observeGet(c.getOutcome(conn, err), time.Since(start))
+	return conn, err
+}
+
+// getOutcome 根据 get 的返回值判断这次 Get 的结果
+func (c *channelPool) getOutcome(conn interface{}, err error) GetOutcome {
+	if err != nil {
+		return GetMiss
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if wrapConn, ok := c.active[conn]; ok && wrapConn.dialed {
+		return GetDial
+	}
+	return GetHit
+}
+
+// putOutcome 根据关闭记录判断 Put 之后连接是否已被关闭. 后台重置(AsyncReset)的结果要稍后才知道, 按放回计
+func (c *channelPool) putOutcome(conn interface{}) PutOutcome {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if _, closed := c.closedKeys[conn]; closed {
+		return PutClosed
+	}
+	return PutPooled
+}
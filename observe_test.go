@@ -0,0 +1,92 @@
+package mypool
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserveGetAndPut(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	record := func(kind string) func(fmt.Stringer, time.Duration) {
+		return func(outcome fmt.Stringer, latency time.Duration) {
+			if latency < 0 {
+				t.Errorf("%s latency %v", kind, latency)
+			}
+			mu.Lock()
+			seen = append(seen, kind+" "+outcome.String())
+			mu.Unlock()
+		}
+	}
+	observeGet, observePut := record("get"), record("put")
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2,
+		ObserveGet: func(o GetOutcome, d time.Duration) { observeGet(o, d) },
+		ObservePut: func(o PutOutcome, d time.Duration) { observePut(o, d) },
+	})
+	a, _ := p.Get() //初始连接
+	b, _ := p.Get() //新建
+	if _, err := p.Get(); err == nil {
+		t.Fatal("Get beyond MaxCap succeeded")
+	}
+	_ = p.Put(a) //放入空闲缓冲
+	_ = p.Put(b) //空闲缓冲已满, 关闭
+
+	want := "get hit, get dial, get miss, put pooled, put closed"
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(seen, ", "); got != want {
+		t.Fatalf("observations = %q, want %q", got, want)
+	}
+}
+
+func TestObserveGetCoversAllEntryPoints(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 2, MaxCap: 3, ObserveGet: func(o GetOutcome, _ time.Duration) {
+		mu.Lock()
+		seen = append(seen, o.String())
+		mu.Unlock()
+	}})
+	report := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := strings.Join(seen, ", ")
+		seen = nil
+		return got
+	}
+
+	tagged, _ := p.GetTagged("") //初始连接没有标签
+	_ = p.Put(tagged)
+	matched, _ := p.GetMatching(func(interface{}) bool { return true })
+	_ = p.Put(matched)
+	longest, _ := p.GetLongestLived()
+	_ = p.Put(longest)
+	if got := report(); got != "hit, hit, hit" {
+		t.Fatalf("idle getters reported %q, want one hit each", got)
+	}
+
+	r, err := p.Reserve(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved, _ := r.Acquire()
+	a, _ := p.Get()
+	b, _, _ := p.GetOrCreate() //没有空闲连接, 新建第 3 条
+	if got := report(); got != "hit, hit, dial" {
+		t.Fatalf("Acquire, Get and GetOrCreate reported %q, want hit, hit, dial", got)
+	}
+
+	if _, err := p.GetWithPolicy(RetryPolicy{MaxAttempts: 3}); err == nil {
+		t.Fatal("GetWithPolicy beyond MaxCap succeeded")
+	}
+	if got := report(); got != "miss" {
+		t.Fatalf("GetWithPolicy reported %q, want a single miss after its retries", got)
+	}
+	for _, conn := range []interface{}{reserved, a} {
+		_ = p.Put(conn)
+	}
+	_ = p.Close(b)
+}
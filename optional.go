@@ -0,0 +1,234 @@
+package mypool
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// 以下可选接口由 NewChannelPool 返回的连接池实现, 通过类型断言使用, 例如
+//
+//	if e, ok := p.(mypool.Evicter); ok {
+//		_ = e.Evict(conn)
+//	}
+//
+// Pool 只保留最基本的方法, 自行实现 Pool 的包装或 mock 不必跟着新功能变化
+
+// Evicter 强制关闭指定连接, 空闲的立即关闭, 借出的在放回时关闭
+type Evicter interface {
+	Evict(conn interface{}) error
+}
+
+// TenantGetter 以租户身份获取连接, 受 MaxPerTenant 限制
+type TenantGetter interface {
+	GetForTenant(tenant string) (interface{}, error)
+}
+
+// Inspector 诊断用的只读信息
+type Inspector interface {
+	// 连接池运行状态统计
+	Stats() Stats
+	// 以可读文本返回连接池的完整状态
+	Dump() string
+	// 空闲连接按存活时长分桶计数
+	IdleAgeHistogram() map[string]int
+}
+
+// PolicyGetter 按重试策略获取连接
+type PolicyGetter interface {
+	GetWithPolicy(policy RetryPolicy) (interface{}, error)
+}
+
+// IdleCounter 无锁读取空闲连接数
+type IdleCounter interface {
+	IdleLen() int
+}
+
+// LoadReporter 报告连接池的负载系数, 用于自适应并发控制
+type LoadReporter interface {
+	LoadFactor() float64
+}
+
+// StatsViewer 提供只读、实时的运行状态视图
+type StatsViewer interface {
+	StatsView() PoolStats
+}
+
+// OverflowGetter 达到上限时仍新建一条连接, 第二个返回值为 true 表示超限连接, 用完应关闭
+type OverflowGetter interface {
+	GetOrCreate() (interface{}, bool, error)
+}
+
+// InfoGetter 获取连接及其元信息(创建时刻、使用次数等)
+type InfoGetter interface {
+	GetWithInfo() (interface{}, ConnInfo, error)
+}
+
+// FreshGetter 获取空闲时长不超过 maxAge 的连接
+type FreshGetter interface {
+	GetFresh(maxAge time.Duration) (interface{}, error)
+}
+
+// LongestLivedGetter 获取剩余存活时间最长的空闲连接, 减少使用中途被淘汰的可能
+type LongestLivedGetter interface {
+	GetLongestLived() (interface{}, error)
+}
+
+// Degrader 手动切换降级状态, 降级时 Get 不再新建连接
+type Degrader interface {
+	SetDegraded(degraded bool)
+}
+
+// FactorySwapper 替换工厂, 之后新建的连接使用新工厂, 已有连接仍由原工厂关闭和检查
+type FactorySwapper interface {
+	SetFactory(factory ConnectionFactory)
+}
+
+// HookReleaser 释放所有连接, 关闭每条空闲连接前先对其调用 fn
+type HookReleaser interface {
+	ReleaseWithHook(fn func(conn interface{}))
+}
+
+// ReadyWaiter 等待连接池可用
+type ReadyWaiter interface {
+	// 初始连接填充完成后关闭的 channel
+	Ready() <-chan struct{}
+	// 等到有空闲连接或还能新建连接时返回, 不消耗连接
+	WaitReady(ctx context.Context) error
+}
+
+// Flusher 先建后拆地替换所有空闲连接, 借出的连接在放回时替换
+type Flusher interface {
+	Flush() error
+}
+
+// Recycler 先建后拆地替换 RecyclePredicate 选中的空闲连接, 返回替换的条数
+type Recycler interface {
+	Recycle() (int, error)
+}
+
+// WaiterFailer 让所有阻塞等待的 Get 立即返回 err, 返回失败的等待者个数
+type WaiterFailer interface {
+	FailWaiters(err error) int
+}
+
+// Transferrer 从 src 接手最多 n 条空闲连接, 返回接手的条数
+type Transferrer interface {
+	TransferFrom(src Pool, n int) (int, error)
+}
+
+// Resizer 调整空闲连接上限, 不能超过创建时的 MaxIdle
+type Resizer interface {
+	Resize(maxIdle int) error
+}
+
+// Reserver 为突发流量预留连接, 预留的连接只有持有者能取出
+type Reserver interface {
+	Reserve(n int) (Reservation, error)
+}
+
+// Booster 临时提高连接数上限, 到期后自动恢复
+type Booster interface {
+	BoostCap(extra int, d time.Duration) error
+}
+
+// Drainer 把连接数降到 n 以内, 最多等待 grace 让借出的连接放回
+type Drainer interface {
+	DrainTo(n int, grace time.Duration) error
+}
+
+// Warmer 预先新建空闲连接, 受 MaxIdle 和连接数上限限制
+type Warmer interface {
+	WarmUp(n int) error
+	WarmUpConcurrent(n, parallelism int) error
+}
+
+// Owner 判断连接是否属于该连接池, 避免把连接放回别的连接池
+type Owner interface {
+	Owns(conn interface{}) bool
+}
+
+// Settings 读取运行时生效的配置
+type Settings interface {
+	IdleTimeout() time.Duration
+	MaxActive() int
+	MaxIdle() int
+	InitialCap() int
+}
+
+// HealthReporter 报告连接池能否正常提供连接, 用于就绪探针
+type HealthReporter interface {
+	Healthy() bool
+}
+
+// SelfTester 借出、检查并放回一条连接, 验证连接池配置可用
+type SelfTester interface {
+	SelfTest(ctx context.Context) error
+}
+
+// PingAller 在 ctx 结束前检查所有空闲连接, 返回通过和失败的条数
+type PingAller interface {
+	PingAll(ctx context.Context) (ok, failed int)
+}
+
+// ConnChecker 并发检查所有空闲连接, 返回每条连接的结果
+type ConnChecker interface {
+	CheckAll(ctx context.Context) []ConnCheckResult
+}
+
+// ContextGetter 在 ctx 结束前获取连接
+type ContextGetter interface {
+	GetContext(ctx context.Context) (interface{}, error)
+}
+
+// DebugHandler 提供调试用的 HTTP 页面
+type DebugHandler interface {
+	Handler() http.Handler
+}
+
+// TaggedGetter 获取配置为指定标签的连接
+type TaggedGetter interface {
+	GetTagged(tag string) (interface{}, error)
+}
+
+// MatchingGetter 获取满足条件的空闲连接, 没有时新建
+type MatchingGetter interface {
+	GetMatching(pred func(conn interface{}) bool) (interface{}, error)
+}
+
+var (
+	_ Evicter            = (*channelPool)(nil)
+	_ TenantGetter       = (*channelPool)(nil)
+	_ Inspector          = (*channelPool)(nil)
+	_ StatsViewer        = (*channelPool)(nil)
+	_ LoadReporter       = (*channelPool)(nil)
+	_ IdleCounter        = (*channelPool)(nil)
+	_ PolicyGetter       = (*channelPool)(nil)
+	_ OverflowGetter     = (*channelPool)(nil)
+	_ InfoGetter         = (*channelPool)(nil)
+	_ FreshGetter        = (*channelPool)(nil)
+	_ LongestLivedGetter = (*channelPool)(nil)
+	_ Degrader           = (*channelPool)(nil)
+	_ FactorySwapper     = (*channelPool)(nil)
+	_ HookReleaser       = (*channelPool)(nil)
+	_ ReadyWaiter        = (*channelPool)(nil)
+	_ Flusher            = (*channelPool)(nil)
+	_ Recycler           = (*channelPool)(nil)
+	_ WaiterFailer       = (*channelPool)(nil)
+	_ Transferrer        = (*channelPool)(nil)
+	_ Resizer            = (*channelPool)(nil)
+	_ Reserver           = (*channelPool)(nil)
+	_ Drainer            = (*channelPool)(nil)
+	_ Booster            = (*channelPool)(nil)
+	_ Warmer             = (*channelPool)(nil)
+	_ Owner              = (*channelPool)(nil)
+	_ Settings           = (*channelPool)(nil)
+	_ HealthReporter     = (*channelPool)(nil)
+	_ SelfTester         = (*channelPool)(nil)
+	_ PingAller          = (*channelPool)(nil)
+	_ ConnChecker        = (*channelPool)(nil)
+	_ ContextGetter      = (*channelPool)(nil)
+	_ DebugHandler       = (*channelPool)(nil)
+	_ TaggedGetter       = (*channelPool)(nil)
+	_ MatchingGetter     = (*channelPool)(nil)
+)
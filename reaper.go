@@ -0,0 +1,282 @@
+package mypool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lingerBatch 一批等待延迟关闭的连接
+type lingerBatch struct {
+	conns []*idleConn
+	timer *time.Timer
+}
+
+// startReaper 启动后台回收协程, 每隔 interval 清理一次空闲缓冲, Release 时退出
+func (c *channelPool) startReaper(interval time.Duration) {
+	done := make(chan struct{})
+	c.reaperDone = done
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.reap()
+				if c.replaceBadIdle {
+					c.replaceBad()
+				}
+				if c.standbyCount > 0 {
+					c.refreshStandby()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// reap 从空闲缓冲里取出空闲超时或超过存活时间的连接并回收
+func (c *channelPool) reap() {
+	c.mu.Lock()
+	expired := c.filterIdleLocked(func(wrapConn *idleConn) bool {
+		return !c.idleExpired(wrapConn) && !wrapConn.lifetimeExpired()
+	})
+	c.mu.Unlock()
+	c.retire(expired)
+}
+
+// replaceBad 逐条检查空闲连接, 失效的关闭后新建连接顶替, 保持热连接数量稳定.
+// 每次只把正在检查的一条移出空闲缓冲, 避免和借出方同时使用, 其余连接照常可借.
+// 检查通过的连接放回时保留原来的 lastUsed, 不会推迟空闲超时
+func (c *channelPool) replaceBad() {
+	c.mu.Lock()
+	snapshot := c.idleSnapshotLocked()
+	c.mu.Unlock()
+
+	for _, wrapConn := range snapshot {
+		c.mu.Lock()
+		target := wrapConn
+		taken := c.filterIdleLocked(func(w *idleConn) bool { return w != target })
+		c.mu.Unlock()
+		if len(taken) == 0 { //已经被借走或关闭
+			continue
+		}
+		if err := c.reaperPing(wrapConn); err == nil && !c.pingFailuresExceeded(wrapConn) {
+			wrapConn.lastValidated = time.Now()
+			_ = c.requeueIdleConn(wrapConn)
+			continue
+		}
+		c.closeNow(taken)
+		if err := c.dialIdle(); err != nil {
+			return
+		}
+	}
+}
+
+// PingAll 逐条检查空闲连接, 用于就绪探针. 按快照顺序每次只取出一条, 通过的连接带着原来的空闲时刻放回,
+// 失败的关闭(不新建顶替). ctx 结束时停止, 正在检查的连接原样放回, 它和尚未检查的连接都不计入返回值
+func (c *channelPool) PingAll(ctx context.Context) (ok, failed int) {
+	c.mu.Lock()
+	snapshot := c.idleSnapshotLocked()
+	c.mu.Unlock()
+
+	for _, wrapConn := range snapshot {
+		if ctx.Err() != nil {
+			break
+		}
+		c.mu.Lock()
+		target := wrapConn
+		taken := c.filterIdleLocked(func(w *idleConn) bool { return w != target })
+		c.mu.Unlock()
+		if len(taken) == 0 { //已经被借走或关闭
+			continue
+		}
+		err := c.pingContext(ctx, wrapConn)
+		if err != nil && ctx.Err() != nil { //调用方的 ctx 到期, 说明不了连接的好坏, 原样放回
+			_ = c.requeueIdleConn(wrapConn)
+			break
+		}
+		if err != nil || c.pingFailuresExceeded(wrapConn) {
+			failed++
+			c.closeNow(taken)
+			continue
+		}
+		ok++
+		wrapConn.lastValidated = time.Now()
+		_ = c.requeueIdleConn(wrapConn)
+	}
+	return ok, failed
+}
+
+// checkAllParallelism CheckAll 同时检查的连接数上限
+const checkAllParallelism = 8
+
+// ConnCheckResult CheckAll 对一条空闲连接的检查结果
+type ConnCheckResult struct {
+	ID      string        // 工厂实现了 Identifier 时为连接的标识, 否则为空
+	Healthy bool          // Ping 是否通过
+	Latency time.Duration // Ping 耗时
+	Err     error         // Ping 失败的错误
+}
+
+// CheckAll 并发检查所有空闲连接, 同时最多检查 checkAllParallelism 条, 返回每条连接的结果, 用于详细的就绪报告.
+// 与 PingAll 一样, 检查中的连接暂时移出空闲缓冲, 通过的放回, 失败的关闭. ctx 结束后不再开始新的检查,
+// 尚未检查的连接保持不动, 也不出现在结果中
+func (c *channelPool) CheckAll(ctx context.Context) []ConnCheckResult {
+	c.mu.Lock()
+	snapshot := c.idleSnapshotLocked()
+	c.mu.Unlock()
+
+	results := make([]*ConnCheckResult, len(snapshot))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(checkAllParallelism, len(snapshot)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = c.checkIdle(ctx, snapshot[i])
+			}
+		}()
+	}
+	for i := range snapshot {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	checked := make([]ConnCheckResult, 0, len(results))
+	for _, r := range results {
+		if r != nil {
+			checked = append(checked, *r)
+		}
+	}
+	return checked
+}
+
+// checkIdle 取出一条空闲连接检查并记录结果, 已被借走或关闭时返回 nil
+func (c *channelPool) checkIdle(ctx context.Context, wrapConn *idleConn) *ConnCheckResult {
+	c.mu.Lock()
+	taken := c.filterIdleLocked(func(w *idleConn) bool { return w != wrapConn })
+	id := connID(c.factoryOfLocked(wrapConn), wrapConn.conn)
+	c.mu.Unlock()
+	if len(taken) == 0 {
+		return nil
+	}
+	start := time.Now()
+	err := c.pingContext(ctx, wrapConn)
+	result := &ConnCheckResult{ID: id, Healthy: err == nil, Latency: time.Since(start), Err: err}
+	if err != nil {
+		c.closeNow(taken)
+		return result
+	}
+	wrapConn.lastValidated = time.Now()
+	_ = c.requeueIdleConn(wrapConn)
+	return result
+}
+
+// reaperPing 回收协程检查一条空闲连接, 配置了 ReaperPingTimeout 时最多等待该时长, 超时返回 ctx 的错误
+func (c *channelPool) reaperPing(wrapConn *idleConn) error {
+	if c.reaperPingTimeout <= 0 {
+		return c.ping(wrapConn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.reaperPingTimeout)
+	defer cancel()
+	err := c.pingContext(ctx, wrapConn)
+	if err != nil && ctx.Err() != nil { //超时是连接卡住了, 计入 Ping 失败
+		c.recordPingFailure(wrapConn, err)
+	}
+	return err
+}
+
+// pingContext 在 ctx 结束前检查一条连接, ctx 先结束时返回 ctx 的错误. ctx 结束引起的失败不计入 Ping 失败次数,
+// 由调用方决定是否算作连接的问题
+func (c *channelPool) pingContext(ctx context.Context, wrapConn *idleConn) error {
+	c.mu.RLock()
+	factory := c.factoryOfLocked(wrapConn)
+	c.mu.RUnlock()
+	if factory == nil {
+		return ErrClosed
+	}
+	if pc, ok := factory.(PingContexter); ok {
+		err := pc.PingContext(ctx, wrapConn.conn)
+		if err != nil && ctx.Err() == nil {
+			c.recordPingFailure(wrapConn, err)
+		}
+		c.checkShutdown(err)
+		return err
+	}
+	// 工厂不支持 ctx, 在后台 Ping, 超时后不再等它; 连接随后被关闭, 卡住的 Ping 一般会随之返回
+	result := make(chan error, 1)
+	go func() {
+		result <- factory.Ping(wrapConn.conn)
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			c.recordPingFailure(wrapConn, err)
+		}
+		c.checkShutdown(err)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retire 回收一批已移出空闲缓冲的连接. 配置了 CloseLinger 时先放进关闭队列, 到期后再关闭,
+// openingConns 在真正关闭时才扣减
+func (c *channelPool) retire(wrapConns []*idleConn) {
+	if len(wrapConns) == 0 {
+		return
+	}
+	if c.closeLinger <= 0 {
+		c.closeNow(wrapConns)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil { //连接池已释放, 不再延迟
+		go c.closeNow(wrapConns)
+		return
+	}
+	batch := &lingerBatch{conns: wrapConns}
+	batch.timer = time.AfterFunc(c.closeLinger, func() {
+		if c.dropLingering(batch) {
+			c.closeNow(batch.conns)
+		}
+	})
+	c.lingering = append(c.lingering, batch)
+}
+
+// dropLingering 把到期的一批连接移出关闭队列, 已被 Release 取走时返回 false
+func (c *channelPool) dropLingering(batch *lingerBatch) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, b := range c.lingering {
+		if b == batch {
+			c.lingering = append(c.lingering[:i], c.lingering[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// closeNow 扣减名额并批量关闭一批连接
+func (c *channelPool) closeNow(wrapConns []*idleConn) {
+	c.mu.Lock()
+	wrapConns = c.claimCloseAllLocked(wrapConns)
+	for range wrapConns {
+		c.releaseSlotLocked()
+	}
+	fallback := c.factory
+	c.mu.Unlock()
+	c.closeIdleConns(fallback, wrapConns)
+}
@@ -0,0 +1,231 @@
+package mypool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// slowPingFactory Ping 前先等 delay
+type slowPingFactory struct {
+	*testutil.MockFactory
+	delay time.Duration
+}
+
+func (f *slowPingFactory) Ping(conn interface{}) error {
+	time.Sleep(f.delay)
+	return f.MockFactory.Ping(conn)
+}
+
+func TestReplaceBadKeepsIdleClock(t *testing.T) {
+	_, f := newTestPool(t, &PoolConfig{
+		InitialCap:      2,
+		MaxIdle:         2,
+		MaxCap:          2,
+		IdleTimeout:     100 * time.Millisecond,
+		IdleFromLastUse: true,
+		ReapInterval:    30 * time.Millisecond,
+		ReplaceBadIdle:  true,
+	})
+	waitFor(t, "idle connections to expire", func() bool { return f.Closed() == 2 })
+}
+
+func TestReplaceBadChecksOneAtATime(t *testing.T) {
+	f := &slowPingFactory{MockFactory: testutil.NewMockFactory(), delay: 50 * time.Millisecond}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, Factory: f, ValidationTTL: time.Hour})
+	done := make(chan struct{})
+	go func() {
+		p.replaceBad()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond) //第一条连接正在检查
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Created() != 3 {
+		t.Fatalf("Get dialed during the check: created = %d, want 3", f.Created())
+	}
+	_ = p.Put(conn)
+	<-done
+}
+
+func TestReplaceBadReplacesBroken(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 2})
+	bad := (<-p.conns)
+	p.conns <- bad
+	f.Break(bad.conn.(*testutil.MockConn))
+	p.replaceBad()
+	if f.Closed() != 1 || f.Created() != 3 || p.Len() != 2 {
+		t.Fatalf("closed %d, created %d, idle %d", f.Closed(), f.Created(), p.Len())
+	}
+}
+
+func TestReaperLingersBeforeClose(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{
+		InitialCap:   2,
+		MaxIdle:      2,
+		MaxCap:       2,
+		IdleTimeout:  10 * time.Millisecond,
+		ReapInterval: 10 * time.Millisecond,
+		CloseLinger:  100 * time.Millisecond,
+	})
+	waitFor(t, "reaper to take expired connections", func() bool { return p.Len() == 0 })
+	if f.Closed() != 0 || p.Stats().OpenConns != 2 {
+		t.Fatalf("closed %d, OpenConns = %d while lingering", f.Closed(), p.Stats().OpenConns)
+	}
+	waitFor(t, "lingering connections to close", func() bool { return f.Closed() == 2 })
+	if p.Stats().OpenConns != 0 {
+		t.Fatalf("OpenConns = %d after linger", p.Stats().OpenConns)
+	}
+}
+
+func TestReleaseClosesLingering(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{
+		InitialCap:   2,
+		MaxIdle:      2,
+		MaxCap:       2,
+		IdleTimeout:  5 * time.Millisecond,
+		ReapInterval: 5 * time.Millisecond,
+		CloseLinger:  time.Hour,
+	})
+	waitFor(t, "reaper to take expired connections", func() bool { return p.Len() == 0 })
+	p.Release()
+	if f.Closed() != 2 {
+		t.Fatalf("Release closed %d lingering connections, want 2", f.Closed())
+	}
+}
+
+// hangingPingFactory 对 hangID 的 Ping 一直卡住, 直到 hang 被关闭
+type hangingPingFactory struct {
+	*testutil.MockFactory
+	hangID int
+	hang   chan struct{}
+}
+
+func (f *hangingPingFactory) Ping(conn interface{}) error {
+	if conn.(*testutil.MockConn).ID == f.hangID {
+		<-f.hang
+		return testutil.ErrMockPing
+	}
+	return f.MockFactory.Ping(conn)
+}
+
+// ctxPingFactory 在 hangingPingFactory 基础上实现 PingContexter, 卡住的 Ping 随 ctx 返回
+type ctxPingFactory struct {
+	*hangingPingFactory
+}
+
+func (f ctxPingFactory) PingContext(ctx context.Context, conn interface{}) error {
+	if conn.(*testutil.MockConn).ID == f.hangID {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return f.MockFactory.Ping(conn)
+}
+
+func TestReaperPingTimeout(t *testing.T) {
+	for _, withContext := range []bool{true, false} {
+		hanging := &hangingPingFactory{MockFactory: testutil.NewMockFactory(), hangID: 1, hang: make(chan struct{})}
+		t.Cleanup(func() { close(hanging.hang) })
+		var factory ConnectionFactory = hanging
+		if withContext {
+			factory = ctxPingFactory{hanging}
+		}
+		start := time.Now()
+		p, _ := newTestPool(t, &PoolConfig{
+			InitialCap:        2,
+			MaxIdle:           2,
+			MaxCap:            2,
+			Factory:           factory,
+			ReapInterval:      10 * time.Millisecond,
+			ReplaceBadIdle:    true,
+			ReaperPingTimeout: 20 * time.Millisecond,
+		})
+		waitFor(t, "hung connection to be replaced", func() bool { return hanging.Created() == 3 })
+		if d := time.Since(start); d > 300*time.Millisecond {
+			t.Fatalf("PingContexter=%v: reaper took %s to get past the hung ping", withContext, d)
+		}
+		if hanging.Closed() != 1 || p.Stats().OpenConns != 2 {
+			t.Fatalf("PingContexter=%v: closed %d, OpenConns = %d", withContext, hanging.Closed(), p.Stats().OpenConns)
+		}
+	}
+}
+
+func TestPingAllUnderDeadline(t *testing.T) {
+	hanging := &hangingPingFactory{MockFactory: testutil.NewMockFactory(), hangID: 3, hang: make(chan struct{})}
+	t.Cleanup(func() { close(hanging.hang) })
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: hanging})
+	p.mu.Lock()
+	idle := p.idleSnapshotLocked()
+	p.mu.Unlock()
+	hanging.Break(idle[1].conn.(*testutil.MockConn))
+
+	// 第 1 条通过, 第 2 条失败, 第 3 条卡到 ctx 到期, 第 4 条来不及检查
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	ok, failed := p.PingAll(ctx)
+	if ok != 1 || failed != 1 {
+		t.Fatalf("PingAll = %d ok, %d failed; want 1 and 1", ok, failed)
+	}
+	if p.Len() != 3 {
+		t.Fatalf("idle=%d, want the passing, the timed-out and the unchecked connection kept", p.Len())
+	}
+	waitFor(t, "failed connection closed", func() bool { return hanging.Closed() == 1 })
+	time.Sleep(10 * time.Millisecond)
+	if hanging.Closed() != 1 {
+		t.Fatalf("closed %d, want only the broken connection closed", hanging.Closed())
+	}
+}
+
+func TestPingAllDeadlineKeepsHealthyPool(t *testing.T) {
+	f := slowIdentFactory{identFactory: identFactory{testutil.NewMockFactory()}, delay: 20 * time.Millisecond}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, Factory: f})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if ok, failed := p.PingAll(ctx); ok != 0 || failed != 0 {
+		t.Fatalf("PingAll = %d ok, %d failed; want a short probe to count nothing", ok, failed)
+	}
+	if p.Len() != 3 || f.Closed() != 0 {
+		t.Fatalf("idle=%d closed=%d, want the healthy pool left intact", p.Len(), f.Closed())
+	}
+}
+
+// slowIdentFactory 同 slowPingFactory, 另外实现 Identifier
+type slowIdentFactory struct {
+	identFactory
+	delay time.Duration
+}
+
+func (f slowIdentFactory) Ping(conn interface{}) error {
+	time.Sleep(f.delay)
+	return f.MockFactory.Ping(conn)
+}
+
+func TestCheckAllReportsEachConn(t *testing.T) {
+	f := slowIdentFactory{identFactory{testutil.NewMockFactory()}, 30 * time.Millisecond}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, Factory: f})
+	f.Break(&testutil.MockConn{ID: 2})
+	f.Break(&testutil.MockConn{ID: 4})
+
+	start := time.Now()
+	results := p.CheckAll(context.Background())
+	if elapsed := time.Since(start); elapsed >= 4*f.delay {
+		t.Fatalf("CheckAll took %v, connections were not checked concurrently", elapsed)
+	}
+	if len(results) != 4 {
+		t.Fatalf("CheckAll returned %d results, want 4", len(results))
+	}
+	for _, r := range results {
+		wantHealthy := r.ID == "mock-1" || r.ID == "mock-3"
+		if r.Healthy != wantHealthy || (r.Err == nil) != wantHealthy || r.Latency < f.delay {
+			t.Errorf("result %+v, want healthy=%v with latency >= %v", r, wantHealthy, f.delay)
+		}
+	}
+	if p.Len() != 2 {
+		t.Fatalf("idle=%d, want the 2 healthy connections kept", p.Len())
+	}
+	waitFor(t, "unhealthy connections closed", func() bool { return f.Closed() == 2 })
+}
@@ -0,0 +1,54 @@
+package mypool
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Pool) // Register 登记的连接池, 按名字共享
+)
+
+// Register 用 poolConfig 创建连接池并以 name 登记, 代码其他地方可通过 Lookup 取到同一个连接池.
+// 名字已被占用时返回错误; 创建在锁外进行, 慢速的初始填充不阻塞其他登记和查找
+func Register(name string, poolConfig *PoolConfig) error {
+	registryMu.Lock()
+	_, exists := registry[name]
+	registryMu.Unlock()
+	if exists {
+		return fmt.Errorf("pool %q is already registered", name)
+	}
+	p, err := NewChannelPool(poolConfig)
+	if err != nil {
+		return err
+	}
+	registryMu.Lock()
+	if _, exists := registry[name]; exists { //创建期间被并发登记了
+		registryMu.Unlock()
+		p.Release()
+		return fmt.Errorf("pool %q is already registered", name)
+	}
+	registry[name] = p
+	registryMu.Unlock()
+	return nil
+}
+
+// Lookup 返回以 name 登记的连接池
+func Lookup(name string) (Pool, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, ok := registry[name]
+	return p, ok
+}
+
+// CloseAll 释放并注销所有登记的连接池, 之后可以重新登记同样的名字
+func CloseAll() {
+	registryMu.Lock()
+	pools := registry
+	registry = make(map[string]Pool)
+	registryMu.Unlock()
+	for _, p := range pools {
+		p.Release()
+	}
+}
@@ -0,0 +1,39 @@
+package mypool
+
+import (
+	"testing"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Cleanup(CloseAll)
+	f := testutil.NewMockFactory()
+	if err := Register("orders", &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1, Factory: f}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register("orders", &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f}); err == nil {
+		t.Fatal("duplicate Register succeeded")
+	}
+	if _, ok := Lookup("users"); ok {
+		t.Fatal("Lookup found an unregistered pool")
+	}
+	p, ok := Lookup("orders")
+	if !ok || p.Len() != 1 {
+		t.Fatalf("Lookup(orders) = %v, %v; want the registered pool", p, ok)
+	}
+
+	CloseAll()
+	if _, ok := Lookup("orders"); ok {
+		t.Fatal("pool still registered after CloseAll")
+	}
+	if _, err := p.Get(); err != ErrClosed {
+		t.Fatalf("Get after CloseAll = %v, want ErrClosed", err)
+	}
+	if f.Closed() != 1 {
+		t.Fatalf("closed=%d, want the idle connection released", f.Closed())
+	}
+	if err := Register("orders", &PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: f}); err != nil {
+		t.Fatalf("Register after CloseAll: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package mypool
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrReservationEmpty 预留的连接已经全部取出或预留已释放
+var ErrReservationEmpty = errors.New("reservation has no connections left")
+
+// Reservation Reserve 预留的一批连接, 只有持有者能通过 Acquire 取出
+type Reservation interface {
+	// 取出一条预留的连接, 用完按普通连接 Put 或 Close
+	Acquire() (interface{}, error)
+	// 释放预留, 还没取出的连接放回连接池供所有人使用
+	Release()
+	// 还没取出的预留连接数
+	Len() int
+}
+
+// reservation Reservation 的实现, 预留的连接计入 openingConns, 但不在空闲缓冲和借出表中
+type reservation struct {
+	pool  *channelPool
+	mu    sync.Mutex
+	conns []*idleConn
+}
+
+// Reserve 为即将到来的突发流量预先新建 n 条连接, 它们占用名额但不会被 Get 取走.
+// 名额不足时返回 ErrMaxActiveConnReached, 拨号失败时关闭已建好的连接并返回错误.
+// 持有者用完后应调用 Release, 否则剩下的预留连接一直占着名额
+func (c *channelPool) Reserve(n int) (Reservation, error) {
+	if n <= 0 {
+		return nil, errors.New("invalid reservation size")
+	}
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if c.degraded {
+		c.mu.Unlock()
+		return nil, ErrDegraded
+	}
+	if c.openingConns+n > c.maxActive {
+		c.mu.Unlock()
+		return nil, ErrMaxActiveConnReached
+	}
+	factory, gen := c.factory, c.factoryGen
+	c.openingConns += n
+	c.mu.Unlock()
+
+	r := &reservation{pool: c}
+	for i := 0; i < n; i++ {
+		c.waitDialTurn()
+		conn, err := c.create(factory)
+		if err != nil {
+			c.mu.Lock()
+			for j := i; j < n; j++ { //归还还没拨号的名额
+				c.releaseSlotLocked()
+			}
+			c.mu.Unlock()
+			c.closeNow(r.conns)
+			return nil, err
+		}
+		r.conns = append(r.conns, c.newIdleConn(conn, factory, gen))
+	}
+	return r, nil
+}
+
+// Acquire 取出一条预留的连接并登记为借出, 执行 OnGet 和 ApplyDeadline
+func (r *reservation) Acquire() (interface{}, error) {
+	return r.pool.observeGetting(r.acquire)
+}
+
+// acquire Acquire 的实现
+func (r *reservation) acquire() (interface{}, error) {
+	r.mu.Lock()
+	if len(r.conns) == 0 {
+		r.mu.Unlock()
+		return nil, ErrReservationEmpty
+	}
+	wrapConn := r.conns[len(r.conns)-1]
+	r.conns = r.conns[:len(r.conns)-1]
+	r.mu.Unlock()
+
+	c := r.pool
+	if c.getConns() == nil { //连接池已释放
+		_ = c.closeIdleConn(wrapConn)
+		return nil, ErrClosed
+	}
+	if c.onGet != nil {
+		if err := c.onGet(wrapConn.conn); err != nil {
+			_ = c.closeIdleConn(wrapConn)
+			return nil, err
+		}
+	}
+	return c.checkout(wrapConn), nil
+}
+
+// Release 把还没取出的连接按 Put 的流程放回连接池. 可重复调用
+func (r *reservation) Release() {
+	r.mu.Lock()
+	conns := r.conns
+	r.conns = nil
+	r.mu.Unlock()
+	for _, wrapConn := range conns {
+		_ = r.pool.putIdleConn(wrapConn)
+	}
+}
+
+// Len 还没取出的预留连接数
+func (r *reservation) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
@@ -0,0 +1,66 @@
+package mypool
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReserveIsExclusive(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 3, MaxCap: 3})
+	r, err := p.Reserve(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != nil { //剩下的一个名额
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != ErrMaxActiveConnReached {
+		t.Fatalf("Get = %v, want ErrMaxActiveConnReached while 2 slots are reserved", err)
+	}
+	for i := 0; i < 2; i++ {
+		conn, err := r.Acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Put(conn); err != nil { //取出后就是普通连接
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Acquire(); err != ErrReservationEmpty {
+		t.Fatalf("Acquire = %v, want ErrReservationEmpty", err)
+	}
+	if p.Len() != 2 || p.Stats().OpenConns != 3 {
+		t.Fatalf("Len = %d, OpenConns = %d; want 2 and 3", p.Len(), p.Stats().OpenConns)
+	}
+}
+
+func TestReservationReleaseReturnsUnused(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 3, MaxCap: 3})
+	r, _ := p.Reserve(3)
+	if _, err := r.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	r.Release()
+	r.Release()
+	if r.Len() != 0 || p.Len() != 2 || p.Stats().OpenConns != 3 {
+		t.Fatalf("reservation %d, Len = %d, OpenConns = %d; want 0, 2, 3", r.Len(), p.Len(), p.Stats().OpenConns)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("Get of a released reserved conn = %v", err)
+	}
+}
+
+func TestReserveFailures(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 3, MaxCap: 3})
+	if _, err := p.Reserve(4); err != ErrMaxActiveConnReached {
+		t.Fatalf("Reserve over MaxCap = %v, want ErrMaxActiveConnReached", err)
+	}
+	errDial := errors.New("connection refused")
+	f.FailFactory(errDial)
+	if _, err := p.Reserve(2); err != errDial {
+		t.Fatalf("Reserve = %v, want the dial error", err)
+	}
+	if p.Stats().OpenConns != 0 {
+		t.Fatalf("failed Reserve kept %d slots", p.Stats().OpenConns)
+	}
+}
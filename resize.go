@@ -0,0 +1,30 @@
+package mypool
+
+import "errors"
+
+// Resize 调整空闲连接上限, 取值范围为 [0, 创建时的 MaxIdle].
+// 调小时多出的空闲连接立即关闭; 开启 LazyShrink 时保留它们, 只拒绝超出上限的 Put, 由空闲超时逐步关闭
+func (c *channelPool) Resize(maxIdle int) error {
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if maxIdle < 0 || maxIdle > cap(c.conns) {
+		c.mu.Unlock()
+		return errors.New("invalid capacity settings")
+	}
+	c.maxIdle = maxIdle
+	c.notifySpaceLocked()
+	var excess []*idleConn
+	if !c.lazyShrink {
+		kept := 0
+		excess = c.filterIdleLocked(func(*idleConn) bool {
+			kept++
+			return kept <= maxIdle
+		})
+	}
+	c.mu.Unlock()
+	c.retire(excess)
+	return nil
+}
@@ -0,0 +1,32 @@
+package mypool
+
+import "testing"
+
+func TestResizeClosesExcess(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4})
+	if err := p.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "excess idle connections to close", func() bool { return f.Closed() == 3 })
+	if p.Len() != 1 || p.Stats().OpenConns != 1 {
+		t.Fatalf("Len = %d, OpenConns = %d; want 1 and 1", p.Len(), p.Stats().OpenConns)
+	}
+	if err := p.Resize(5); err == nil {
+		t.Fatal("Resize above the configured MaxIdle succeeded")
+	}
+}
+
+func TestResizeLazyShrink(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 4, MaxIdle: 4, MaxCap: 4, LazyShrink: true})
+	if err := p.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	if f.Closed() != 0 || p.Len() != 4 {
+		t.Fatalf("LazyShrink closed %d connections right away, Len = %d", f.Closed(), p.Len())
+	}
+	conn, _ := p.Get()
+	_ = p.Put(conn) //空闲数已超出新上限, 放回的连接被关闭
+	if f.Closed() != 1 || p.Len() != 3 {
+		t.Fatalf("closed %d, Len = %d; want 1 and 3", f.Closed(), p.Len())
+	}
+}
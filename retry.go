@@ -0,0 +1,74 @@
+package mypool
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy Get 遇到暂时性失败时的重试策略
+type RetryPolicy struct {
+	MaxAttempts int           // 最多尝试次数(含第一次), 小于 1 按 1 处理
+	Backoff     time.Duration // 第一次重试前的等待时长, 之后每次翻倍
+	MaxBackoff  time.Duration // 单次等待时长的上限, 为 0 时不设上限
+	//判断错误是否值得重试, 为 nil 时只重试 ErrMaxActiveConnReached 和 ErrDialRateLimited
+	Retryable func(err error) bool
+}
+
+var (
+	// RetryNever 只尝试一次, 与直接 Get 相同
+	RetryNever = RetryPolicy{MaxAttempts: 1}
+	// RetryBriefly 适合在线请求: 最多 3 次, 从 10ms 开始退避
+	RetryBriefly = RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond}
+	// RetryPatiently 适合后台任务: 最多 8 次, 从 50ms 开始退避, 单次最多等 1s
+	RetryPatiently = RetryPolicy{MaxAttempts: 8, Backoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+)
+
+// retryable 按策略判断 err 是否值得重试
+func (policy RetryPolicy) retryable(err error) bool {
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return err == ErrMaxActiveConnReached || err == ErrDialRateLimited
+}
+
+// do 按策略反复调用 get, 直到成功、遇到不可重试的错误或用完尝试次数, 返回最后一次的结果
+func (policy RetryPolicy) do(get func() (interface{}, error)) (interface{}, error) {
+	conn, err := get()
+	delay := policy.Backoff
+	for attempt := 1; attempt < policy.MaxAttempts && err != nil && policy.retryable(err); attempt++ {
+		time.Sleep(delay)
+		if delay *= 2; policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+			delay = policy.MaxBackoff
+		}
+		conn, err = get()
+	}
+	return conn, err
+}
+
+// GetWithPolicy 按 policy 重试 Get, 返回成功的连接或最后一次的错误
+func (c *channelPool) GetWithPolicy(policy RetryPolicy) (interface{}, error) {
+	get := func() (interface{}, error) { return c.getContext(context.Background()) }
+	return c.observeGetting(func() (interface{}, error) { return policy.do(get) })
+}
+
+// retryingPool Get 遇到 ErrMaxActiveConnReached 时退避重试, 其余方法交给内嵌的 Pool
+type retryingPool struct {
+	Pool
+	policy RetryPolicy
+}
+
+// NewRetryingPool 包装 p, Get 因连接数达到上限失败时最多再重试 retries 次,
+// 第一次重试前等待 backoff, 之后每次等待时间翻倍. 其他错误立即返回.
+// 返回值只实现 Pool, 需要可选接口时对 p 本身做类型断言
+func NewRetryingPool(p Pool, retries int, backoff time.Duration) Pool {
+	return &retryingPool{Pool: p, policy: RetryPolicy{
+		MaxAttempts: retries + 1,
+		Backoff:     backoff,
+		Retryable:   func(err error) bool { return err == ErrMaxActiveConnReached },
+	}}
+}
+
+// Get 从内嵌的 Pool 取连接, 达到上限时按退避重试
+func (r *retryingPool) Get() (interface{}, error) {
+	return r.policy.do(r.Pool.Get)
+}
@@ -0,0 +1,103 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+)
+
+// countingPool 记录 Get 的调用次数, 第 freeOn 次 Get 失败后放回 held
+type countingPool struct {
+	Pool
+	gets   int
+	freeOn int
+	held   interface{}
+}
+
+func (p *countingPool) Get() (interface{}, error) {
+	p.gets++
+	conn, err := p.Pool.Get()
+	if err == ErrMaxActiveConnReached && p.gets == p.freeOn {
+		_ = p.Pool.Put(p.held)
+	}
+	return conn, err
+}
+
+func TestRetryingPoolWaitsForFreedConn(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	held, _ := p.Get()
+	inner := &countingPool{Pool: p, freeOn: 2, held: held} //第一次重试失败后放回
+	retrying := NewRetryingPool(inner, 3, time.Millisecond)
+
+	conn, err := retrying.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != held || inner.gets != 3 {
+		t.Fatalf("got the freed conn: %v, attempts = %d; want true and 3", conn == held, inner.gets)
+	}
+}
+
+func TestRetryingPoolGivesUp(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	_, _ = p.Get()
+	inner := &countingPool{Pool: p}
+	if _, err := NewRetryingPool(inner, 2, time.Millisecond).Get(); err != ErrMaxActiveConnReached {
+		t.Fatalf("Get = %v, want ErrMaxActiveConnReached", err)
+	}
+	if inner.gets != 3 {
+		t.Fatalf("attempts = %d, want 1 + 2 retries", inner.gets)
+	}
+
+	p.Release()
+	inner.gets = 0
+	if _, err := NewRetryingPool(inner, 2, time.Millisecond).Get(); err != ErrClosed || inner.gets != 1 {
+		t.Fatalf("Get = %v after %d attempts; want ErrClosed without retrying", err, inner.gets)
+	}
+}
+
+func TestGetWithPolicySucceedsOnThirdAttempt(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	held, _ := p.Get()
+	failures := 0
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Millisecond,
+		Retryable: func(err error) bool {
+			if failures++; failures == 2 { //第二次失败后放回, 第三次成功
+				_ = p.Put(held)
+			}
+			return err == ErrMaxActiveConnReached
+		},
+	}
+	conn, err := p.GetWithPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != held || failures != 2 {
+		t.Fatalf("got the freed conn: %v after %d failures; want true and 2", conn == held, failures)
+	}
+}
+
+func TestRetryPolicyStopsOnOtherErrors(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1})
+	p.SetDegraded(true)
+	attempts := 0
+	_, err := RetryBriefly.do(func() (interface{}, error) {
+		attempts++
+		return p.Get()
+	})
+	if err != ErrDegraded || attempts != 1 {
+		t.Fatalf("err = %v after %d attempts; want ErrDegraded without retrying", err, attempts)
+	}
+
+	p.SetDegraded(false)
+	_, _ = p.Get()
+	attempts = 0
+	_, err = RetryPolicy{MaxAttempts: 3}.do(func() (interface{}, error) {
+		attempts++
+		return p.Get()
+	})
+	if err != ErrMaxActiveConnReached || attempts != 3 {
+		t.Fatalf("err = %v after %d attempts; want ErrMaxActiveConnReached after 3", err, attempts)
+	}
+}
@@ -0,0 +1,78 @@
+package mypool
+
+import "log"
+
+// fillStandby 把备用连接补到 StandbyCount 条, 受 maxActive 限制. 拨号失败只记日志, 下次提升或刷新时再补
+func (c *channelPool) fillStandby() {
+	for {
+		c.mu.Lock()
+		factory, gen := c.factory, c.factoryGen
+		if c.conns == nil || factory == nil || len(c.standby)+c.standbyPending >= c.standbyCount || c.openingConns >= c.maxActive {
+			c.mu.Unlock()
+			return
+		}
+		c.standbyPending++
+		c.openingConns++
+		c.mu.Unlock()
+
+		c.waitDialTurn()
+		conn, err := c.create(factory)
+		c.mu.Lock()
+		c.standbyPending--
+		if err != nil {
+			c.releaseSlotLocked()
+			c.mu.Unlock()
+			log.Printf("factory is not able to fill standby connections: %s", err)
+			return
+		}
+		wrapConn := c.newIdleConn(conn, factory, gen)
+		if c.conns == nil { //拨号期间连接池被释放
+			c.mu.Unlock()
+			_ = c.closeIdleConn(wrapConn)
+			return
+		}
+		c.standby = append(c.standby, wrapConn)
+		c.mu.Unlock()
+	}
+}
+
+// promoteStandbyLocked 取出一条备用连接交给 Get, 没有时返回 nil. 调用方需持有 c.mu, 之后应在锁外补充备用连接
+func (c *channelPool) promoteStandbyLocked() *idleConn {
+	n := len(c.standby)
+	if n == 0 {
+		return nil
+	}
+	wrapConn := c.standby[n-1]
+	c.standby = c.standby[:n-1]
+	return wrapConn
+}
+
+// refreshStandby 检查所有备用连接, 关闭超过存活时间或失效的并补上新的. 由回收协程定期调用
+func (c *channelPool) refreshStandby() {
+	c.mu.Lock()
+	snapshot := c.standby
+	c.standby = nil
+	c.standbyPending += len(snapshot) //检查期间仍算作备用, fillStandby 不会多补
+	c.mu.Unlock()
+
+	var kept, bad []*idleConn
+	for _, wrapConn := range snapshot {
+		if wrapConn.lifetimeExpired() || c.reaperPing(wrapConn) != nil {
+			bad = append(bad, wrapConn)
+			continue
+		}
+		kept = append(kept, wrapConn)
+	}
+	c.mu.Lock()
+	c.standbyPending -= len(snapshot)
+	released := c.conns == nil
+	if !released {
+		c.standby = append(c.standby, kept...)
+	}
+	c.mu.Unlock()
+	if released {
+		bad = append(bad, kept...)
+	}
+	c.closeNow(bad)
+	c.fillStandby()
+}
@@ -0,0 +1,62 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+func TestStandbyUsedAfterIdleDrained(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 4, StandbyCount: 1})
+	waitFor(t, "standby filled", func() bool { return p.Stats().Standby == 1 })
+	p.mu.Lock()
+	standby := p.standby[0].conn
+	p.mu.Unlock()
+
+	// 普通的空闲连接先用完, 备用连接不动
+	for i := 0; i < 2; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn == standby {
+			t.Fatal("standby connection handed out while idle connections remained")
+		}
+	}
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != standby {
+		t.Fatalf("Get = %v, want the promoted standby connection", conn)
+	}
+	// 提升后在后台补上新的备用连接
+	waitFor(t, "standby refilled", func() bool { return p.Stats().Standby == 1 && f.Created() == 4 })
+	if s := p.Stats(); s.OpenConns != 4 || s.InUse != 3 {
+		t.Fatalf("stats = %+v, want 4 open and 3 in use", s)
+	}
+}
+
+func TestStandbyRefresh(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 2, StandbyCount: 1, ReapInterval: 5 * time.Millisecond})
+	waitFor(t, "standby filled", func() bool { return p.Stats().Standby == 1 })
+	p.mu.Lock()
+	old := p.standby[0].conn.(*testutil.MockConn)
+	p.mu.Unlock()
+	f.Break(old)
+	waitFor(t, "broken standby replaced", func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.standby) == 1 && p.standby[0].conn != old
+	})
+	if s := p.Stats(); s.OpenConns != 1 || f.Closed() != 1 {
+		t.Fatalf("open=%d closed=%d, want the broken standby closed and replaced", s.OpenConns, f.Closed())
+	}
+}
+
+func TestStandbyCountValidated(t *testing.T) {
+	if _, err := NewChannelPool(&PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 3, StandbyCount: 2, Factory: testutil.NewMockFactory()}); err == nil {
+		t.Fatal("InitialCap+StandbyCount above MaxCap was accepted")
+	}
+}
@@ -0,0 +1,149 @@
+package mypool
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stats 连接池运行状态
+type Stats struct {
+	OpenConns int   // 当前打开的连接数
+	IdleConns int   // 空闲缓冲中的连接数
+	InUse     int   // 已借出的连接数
+	Waiters   int   // 正在排队等待连接的 Get 数量
+	Standby   int   // 备用连接数, 计入 OpenConns
+	WaitCount int64 // 累计进入等待的 Get 次数
+	MaxActive int   // 当前的连接数上限, 开启 AutoScale 时会变化
+
+	BytesRead    int64 // 连接放回或关闭时汇总的累计读取字节数, 需连接实现 ByteCounter
+	BytesWritten int64 // 同上, 累计写入字节数
+
+	PutDiscardedFull int64 // 放回时空闲缓冲已满而关闭的连接数, 持续增长说明 MaxIdle 偏小
+	IdleBytes        int64 // 空闲连接占用的字节数, 开启 MaxIdleBytes 时才统计
+
+	ClosedConns     int64         // 计入 AvgConnLifetime 的已关闭连接数
+	AvgConnLifetime time.Duration // 已关闭连接从创建到关闭的平均时长, 用于调整 MaxConnLifetime
+}
+
+// Stats 在锁内读取当前的运行状态
+func (c *channelPool) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Stats{
+		OpenConns: c.openingConns,
+		IdleConns: len(c.conns),
+		InUse:     len(c.active),
+		Waiters:   len(c.connReqs),
+		Standby:   len(c.standby),
+		WaitCount: c.waitCount,
+		MaxActive: c.maxActive,
+
+		BytesRead:    c.bytesRead,
+		BytesWritten: c.bytesWritten,
+
+		PutDiscardedFull: c.putDiscardedFull,
+		IdleBytes:        c.idleBytes,
+
+		ClosedConns:     c.closedLifetimeCount,
+		AvgConnLifetime: c.avgLifetimeLocked(),
+	}
+}
+
+// LoadFactor 负载系数, 取值 [0, 1]: (借出的连接数 + 等待的 Get 数) / 连接数上限. 空闲连接不算负载,
+// 达到 1 表示再来的 Get 要等待或失败, 调用方可以提前削减请求. 上限为 0 (如排空后)时为 1
+func (c *channelPool) LoadFactor() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.maxActive <= 0 {
+		return 1
+	}
+	return min(float64(len(c.active)+len(c.connReqs))/float64(c.maxActive), 1)
+}
+
+// avgLifetimeLocked 已关闭连接的平均存活时长, 还没有关闭过连接时为 0. 调用方需持有 c.mu
+func (c *channelPool) avgLifetimeLocked() time.Duration {
+	if c.closedLifetimeCount == 0 {
+		return 0
+	}
+	return c.closedLifetimeSum / time.Duration(c.closedLifetimeCount)
+}
+
+// PoolStats 只读的运行状态, 每次调用都读取连接池当前的计数, 适合交给管理接口使用
+type PoolStats interface {
+	OpenConns() int
+	IdleConns() int
+	InUse() int
+	Waiters() int
+	Standby() int
+	WaitCount() int64
+	MaxActive() int
+	BytesRead() int64
+	BytesWritten() int64
+	PutDiscardedFull() int64
+	IdleBytes() int64
+	ClosedConns() int64
+	AvgConnLifetime() time.Duration
+}
+
+// liveStats PoolStats 的实现, 每个方法都在锁内读取 pool 的计数
+type liveStats struct {
+	pool *channelPool
+}
+
+func (s liveStats) OpenConns() int          { return s.pool.Stats().OpenConns }
+func (s liveStats) IdleConns() int          { return s.pool.Stats().IdleConns }
+func (s liveStats) InUse() int              { return s.pool.Stats().InUse }
+func (s liveStats) Waiters() int            { return s.pool.Stats().Waiters }
+func (s liveStats) Standby() int            { return s.pool.Stats().Standby }
+func (s liveStats) WaitCount() int64        { return s.pool.Stats().WaitCount }
+func (s liveStats) MaxActive() int          { return s.pool.Stats().MaxActive }
+func (s liveStats) BytesRead() int64        { return s.pool.Stats().BytesRead }
+func (s liveStats) BytesWritten() int64     { return s.pool.Stats().BytesWritten }
+func (s liveStats) PutDiscardedFull() int64 { return s.pool.Stats().PutDiscardedFull }
+func (s liveStats) IdleBytes() int64        { return s.pool.Stats().IdleBytes }
+func (s liveStats) ClosedConns() int64      { return s.pool.Stats().ClosedConns }
+
+func (s liveStats) AvgConnLifetime() time.Duration { return s.pool.Stats().AvgConnLifetime }
+
+// StatsView 返回连接池运行状态的只读视图. 与 Stats 的快照不同, 它始终反映最新的计数
+func (c *channelPool) StatsView() PoolStats {
+	return liveStats{pool: c}
+}
+
+var (
+	expvarMu    sync.Mutex
+	expvarPools *expvar.Map // 发布了 ExpvarName 的连接池, 第一次发布时在 "mypool" 下创建
+)
+
+// publishExpvar 名字不为空时把 Stats 以 expvar.Func 登记到 expvarPools, 每次读取都是实时数据.
+// 检查和登记在同一把锁内完成, 并发创建同名连接池时只有一个成功
+func (c *channelPool) publishExpvar(name string) error {
+	if name == "" {
+		return nil
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvarPools == nil {
+		expvarPools = expvar.NewMap("mypool")
+	}
+	if expvarPools.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+	expvarPools.Set(name, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+	c.expvarName = name
+	return nil
+}
+
+// unpublishExpvar 撤销 publishExpvar 登记的名字, 连接池不再被 expvar 引用. 不能在持有 c.mu 时调用
+func unpublishExpvar(name string) {
+	if name == "" {
+		return
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	expvarPools.Delete(name)
+}
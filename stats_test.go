@@ -0,0 +1,252 @@
+package mypool
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// expvarStats 读取 mypool.<name> 下发布的 Stats
+func expvarStats(t *testing.T, name string) (Stats, bool) {
+	t.Helper()
+	root := expvar.Get("mypool")
+	if root == nil {
+		return Stats{}, false
+	}
+	v := root.(*expvar.Map).Get(name)
+	if v == nil {
+		return Stats{}, false
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(v.String()), &stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats, true
+}
+
+func TestExpvarPublishedAndReleased(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 3, ExpvarName: "released"})
+	stats, ok := expvarStats(t, "released")
+	if !ok || stats.OpenConns != 2 || stats.IdleConns != 2 {
+		t.Fatalf("published stats = %+v, %v", stats, ok)
+	}
+	p.Release()
+	if _, ok := expvarStats(t, "released"); ok {
+		t.Fatal("name still published after Release")
+	}
+	newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, ExpvarName: "released"})
+	if _, ok := expvarStats(t, "released"); !ok {
+		t.Fatal("name not republished by the new pool")
+	}
+}
+
+func TestExpvarDuplicateNameConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := NewChannelPool(&PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: nopFactory{}, ExpvarName: "dup"})
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			created++
+			mu.Unlock()
+			t.Cleanup(p.Release)
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Fatalf("%d pools published the same name, want 1", created)
+	}
+}
+
+// nopFactory 什么都不做的工厂, 每次返回新的指针
+type nopFactory struct{}
+
+func (nopFactory) Factory() (interface{}, error) { return new(int), nil }
+func (nopFactory) Close(interface{}) error       { return nil }
+func (nopFactory) Ping(interface{}) error        { return nil }
+
+func TestStatsCountsWaiters(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 1, WaitTimeout: time.Second})
+	conn, _ := p.Get()
+	for i := 0; i < 3; i++ {
+		getAsync(t, p)
+	}
+	stats := p.Stats()
+	if stats.Waiters != 3 || stats.InUse != 1 || stats.OpenConns != 1 || stats.IdleConns != 0 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+	_ = p.Put(conn)
+	waitFor(t, "a waiter to take the connection", func() bool { return p.Stats().Waiters == 2 })
+}
+
+func TestStatsPutDiscardedFull(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 3})
+	var conns []interface{}
+	for i := 0; i < 3; i++ {
+		conn, _ := p.Get()
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		_ = p.Put(conn)
+	}
+	if got := p.Stats().PutDiscardedFull; got != 2 {
+		t.Fatalf("PutDiscardedFull = %d, want 2", got)
+	}
+	if p.Len() != 1 || f.Closed() != 2 {
+		t.Fatalf("Len = %d, closed %d; want 1 and 2", p.Len(), f.Closed())
+	}
+}
+
+func TestStatsViewIsLive(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 3})
+	view := p.StatsView()
+	if view.OpenConns() != 2 || view.IdleConns() != 2 || view.InUse() != 0 {
+		t.Fatalf("initial view: open %d, idle %d, in use %d", view.OpenConns(), view.IdleConns(), view.InUse())
+	}
+	conn, _ := p.Get()
+	_, _ = p.Get()
+	_, _ = p.Get()
+	if view.OpenConns() != 3 || view.IdleConns() != 0 || view.InUse() != 3 {
+		t.Fatalf("view after Gets: open %d, idle %d, in use %d", view.OpenConns(), view.IdleConns(), view.InUse())
+	}
+	_ = p.Close(conn)
+	if view.OpenConns() != 2 || view.InUse() != 2 || view.Waiters() != 0 {
+		t.Fatalf("view after Close: open %d, in use %d, waiters %d", view.OpenConns(), view.InUse(), view.Waiters())
+	}
+}
+
+func TestStatsAvgConnLifetime(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 2})
+	if p.Stats().AvgConnLifetime != 0 {
+		t.Fatal("AvgConnLifetime set before any close")
+	}
+	a, _ := p.Get()
+	b, _ := p.Get()
+	p.mu.Lock()
+	p.active[a].t = time.Now().Add(-10 * time.Minute)
+	p.active[b].t = time.Now().Add(-20 * time.Minute)
+	p.mu.Unlock()
+	_ = p.Close(a)
+	_ = p.Close(b)
+
+	stats := p.Stats()
+	if stats.ClosedConns != 2 {
+		t.Fatalf("ClosedConns = %d, want 2", stats.ClosedConns)
+	}
+	if avg := stats.AvgConnLifetime; avg < 15*time.Minute || avg > 15*time.Minute+time.Second {
+		t.Fatalf("AvgConnLifetime = %s, want about 15m", avg)
+	}
+	if _ = p.Close(a); p.Stats().ClosedConns != 2 {
+		t.Fatal("a repeated Close was counted again")
+	}
+}
+
+// sizedFactory 每条连接占用 connBytes 字节
+type sizedFactory struct {
+	*testutil.MockFactory
+}
+
+const connBytes = 100
+
+func (sizedFactory) Size(interface{}) int64 { return connBytes }
+
+func TestMaxIdleBytes(t *testing.T) {
+	f := sizedFactory{testutil.NewMockFactory()}
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 4, MaxCap: 4, MaxIdleBytes: 2*connBytes + connBytes/2, Factory: f})
+	var conns []interface{}
+	for i := 0; i < 3; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		_ = p.Put(conn)
+	}
+	// 第三条放入后会超出预算, 被关闭
+	if s := p.Stats(); s.IdleConns != 2 || s.IdleBytes != 2*connBytes || f.Closed() != 1 {
+		t.Fatalf("idle=%d bytes=%d closed=%d, want 2, %d, 1", s.IdleConns, s.IdleBytes, f.Closed(), 2*connBytes)
+	}
+	conn, _ := p.Get()
+	if got := p.StatsView().IdleBytes(); got != connBytes {
+		t.Fatalf("IdleBytes after Get = %d, want %d", got, connBytes)
+	}
+	_ = p.Put(conn)
+	if got := p.Stats().IdleBytes; got != 2*connBytes {
+		t.Fatalf("IdleBytes after Put = %d, want %d", got, 2*connBytes)
+	}
+}
+
+func TestLoadFactor(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 2, MaxCap: 4, WaitTimeout: time.Second})
+	if lf := p.LoadFactor(); lf != 0 {
+		t.Fatalf("idle pool load = %v, want 0", lf)
+	}
+	var held []interface{}
+	for i, want := range []float64{0.25, 0.5, 0.75, 1} {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, conn)
+		if lf := p.LoadFactor(); lf != want {
+			t.Fatalf("load after %d checkouts = %v, want %v", i+1, lf, want)
+		}
+	}
+	done := getAsync(t, p)
+	if lf := p.LoadFactor(); lf != 1 {
+		t.Fatalf("load with a waiter = %v, want capped at 1", lf)
+	}
+	_ = p.Put(held[0])
+	<-done
+}
+
+func TestIdleCountTracksBuffer(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 4, MaxCap: 6, ReapInterval: time.Millisecond, IdleTimeout: time.Hour})
+	if p.IdleLen() != 2 {
+		t.Fatalf("IdleLen = %d after fill, want 2", p.IdleLen())
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				conn, err := p.Get()
+				if err != nil {
+					continue
+				}
+				if n := p.Len(); n < 0 || n > 4 {
+					t.Errorf("Len = %d outside [0, MaxIdle]", n)
+				}
+				if i%3 == 0 {
+					_ = p.Close(conn)
+				} else {
+					_ = p.Put(conn)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, "counter to match the buffer", func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.Len() == len(p.conns)
+	})
+
+	p.Release()
+	if p.Len() != 0 {
+		t.Fatalf("Len = %d after Release, want 0", p.Len())
+	}
+}
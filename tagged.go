@@ -0,0 +1,152 @@
+package mypool
+
+import "time"
+
+// GetTagged 取一条配置为 tag 的连接(如选好了库的数据库连接), 找不到时:
+// 配置了 Retag 则把一条其他标签的空闲连接改配为 tag, 否则新建一条.
+// 新建不等待放回, 连接数达到上限时返回 ErrMaxActiveConnReached
+func (c *channelPool) GetTagged(tag string) (interface{}, error) {
+	return c.observeGetting(func() (interface{}, error) { return c.getTagged(tag) })
+}
+
+// getTagged GetTagged 的实现
+func (c *channelPool) getTagged(tag string) (interface{}, error) {
+	if err := c.checkServing(); err != nil {
+		return nil, err
+	}
+	for i := cap(c.getConns()); i > 0; i-- {
+		wrapConn := c.takeIdle(func(w *idleConn) bool { return w.tag == tag })
+		if wrapConn == nil {
+			break
+		}
+		if c.validate(wrapConn) {
+			return c.checkout(wrapConn), nil
+		}
+	}
+	if c.retag != nil {
+		for i := cap(c.getConns()); i > 0; i-- {
+			wrapConn := c.takeIdle(func(*idleConn) bool { return true })
+			if wrapConn == nil {
+				break
+			}
+			if !c.validate(wrapConn) { //先确认连接可用, 不为坏连接改配
+				continue
+			}
+			if err := c.retag(wrapConn.conn, tag); err != nil {
+				c.discard(wrapConn, "retag failed: "+err.Error())
+				continue
+			}
+			wrapConn.tag = tag
+			return c.checkout(wrapConn), nil
+		}
+	}
+	return c.dialTagged(tag)
+}
+
+// GetMatching 取第一条满足 pred 的空闲连接(如协议版本兼容的连接), 不满足的留在空闲缓冲里.
+// 都不满足时新建一条, 新连接也不满足时关闭它并返回 ErrNoMatchingConn. 新建不等待放回, 连接数达到上限时返回 ErrMaxActiveConnReached.
+// pred 在锁外调用, 可以访问连接池; 它看到的空闲连接可能同时被其他 Get 取走, 只应读取连接不会变化的属性
+func (c *channelPool) GetMatching(pred func(conn interface{}) bool) (interface{}, error) {
+	return c.observeGetting(func() (interface{}, error) { return c.getMatching(pred) })
+}
+
+// getMatching GetMatching 的实现
+func (c *channelPool) getMatching(pred func(conn interface{}) bool) (interface{}, error) {
+	if err := c.checkServing(); err != nil {
+		return nil, err
+	}
+	for i := cap(c.getConns()); i > 0; i-- {
+		wrapConn := c.takeMatching(func(w *idleConn) bool { return pred(w.conn) })
+		if wrapConn == nil {
+			break
+		}
+		if c.validate(wrapConn) {
+			return c.checkout(wrapConn), nil
+		}
+	}
+	conn, err := c.dialTagged("")
+	if err != nil {
+		return nil, err
+	}
+	if !pred(conn) {
+		_ = c.Close(conn)
+		return nil, ErrNoMatchingConn
+	}
+	return conn, nil
+}
+
+// takeMatching 与 takeIdle 相同, 但 match 在锁外对空闲连接的快照调用, 选中后再在锁内取出.
+// 选中的连接期间被取走时重新快照, 最多尝试一整个空闲缓冲那么多次
+func (c *channelPool) takeMatching(match func(*idleConn) bool) *idleConn {
+	for i := cap(c.getConns()); i > 0; i-- {
+		c.mu.Lock()
+		snapshot := c.idleSnapshotLocked()
+		c.mu.Unlock()
+		var picked *idleConn
+		for _, wrapConn := range snapshot {
+			if match(wrapConn) {
+				picked = wrapConn
+				break
+			}
+		}
+		if picked == nil {
+			return nil
+		}
+		c.mu.Lock()
+		claimed := len(c.filterIdleLocked(func(wrapConn *idleConn) bool { return wrapConn != picked })) == 1
+		c.mu.Unlock()
+		if claimed {
+			return picked
+		}
+	}
+	return nil
+}
+
+// takeIdle 从空闲缓冲里取出第一条满足 match 的连接, 没有时返回 nil
+func (c *channelPool) takeIdle(match func(*idleConn) bool) *idleConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var picked *idleConn
+	c.filterIdleLocked(func(wrapConn *idleConn) bool {
+		if picked == nil && match(wrapConn) {
+			picked = wrapConn
+			return false
+		}
+		return true
+	})
+	return picked
+}
+
+// dialTagged 新建一条连接并标记为 tag 借出(tag 为空即不配置标签), 不等待名额和拨号间隔
+func (c *channelPool) dialTagged(tag string) (interface{}, error) {
+	c.mu.Lock()
+	factory, gen := c.factory, c.factoryGen
+	if c.conns == nil || factory == nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if c.degraded {
+		c.mu.Unlock()
+		return nil, ErrDegraded
+	}
+	if c.openingConns >= c.maxActive {
+		c.mu.Unlock()
+		return nil, ErrMaxActiveConnReached
+	}
+	if _, err := c.reserveDialLocked(time.Now()); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.openingConns++
+	c.mu.Unlock()
+	conn, err := c.create(factory)
+	if err != nil {
+		c.mu.Lock()
+		c.releaseSlotLocked()
+		c.mu.Unlock()
+		return nil, err
+	}
+	wrapConn := c.newIdleConn(conn, factory, gen)
+	wrapConn.tag = tag
+	return c.checkoutNew(wrapConn)
+}
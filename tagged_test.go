@@ -0,0 +1,160 @@
+package mypool
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetTaggedRetag(t *testing.T) {
+	var retagged []string
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 2, MaxCap: 2, Retag: func(conn interface{}, newTag string) error {
+		retagged = append(retagged, newTag)
+		return nil
+	}})
+	idle, _ := p.Get()
+	_ = p.Put(idle)
+
+	conn, err := p.GetTagged("db2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != idle || f.Created() != 1 || len(retagged) != 1 || retagged[0] != "db2" {
+		t.Fatalf("conn=%v created=%d retagged=%v, want the idle connection repurposed", conn, f.Created(), retagged)
+	}
+	_ = p.Put(conn)
+	// 已经是 db2 的连接直接复用, 不再改配
+	if again, _ := p.GetTagged("db2"); again != idle || len(retagged) != 1 {
+		t.Fatalf("second GetTagged = %v, retagged=%v", again, retagged)
+	}
+}
+
+func TestGetTaggedDialsWithoutRetag(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 2, MaxCap: 2})
+	conn, err := p.GetTagged("db2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Created() != 2 || p.Len() != 1 {
+		t.Fatalf("created=%d idle=%d, want a new connection and the untagged one left idle", f.Created(), p.Len())
+	}
+	_ = p.Put(conn)
+	if again, _ := p.GetTagged("db2"); again != conn {
+		t.Fatal("tag was not kept across Put")
+	}
+}
+
+func TestGetTaggedRespectsDegraded(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	p.SetDegraded(true)
+	if _, err := p.GetTagged(""); err != ErrDegraded {
+		t.Fatalf("GetTagged = %v while degraded, want ErrDegraded", err)
+	}
+}
+
+func TestGetTaggedRetagFailure(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 2, MaxCap: 2, Retag: func(interface{}, string) error {
+		return errors.New("unknown database")
+	}})
+	if _, err := p.GetTagged("db2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "failed retag closed", func() bool { return f.Closed() == 1 })
+	if f.Created() != 2 {
+		t.Fatalf("created=%d, want a fresh connection after the failed retag", f.Created())
+	}
+}
+
+// versionConn 带协议版本的连接
+type versionConn struct {
+	id      int
+	version int
+}
+
+// versionFactory 新建的连接使用当前的 version
+type versionFactory struct {
+	mu      sync.Mutex
+	version int
+	created int
+}
+
+func (f *versionFactory) Factory() (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	return &versionConn{id: f.created, version: f.version}, nil
+}
+
+func (f *versionFactory) Close(interface{}) error { return nil }
+func (f *versionFactory) Ping(interface{}) error  { return nil }
+
+func (f *versionFactory) setVersion(v int) {
+	f.mu.Lock()
+	f.version = v
+	f.mu.Unlock()
+}
+
+func TestGetMatchingSelectsVersion(t *testing.T) {
+	f := &versionFactory{version: 1}
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 2, MaxIdle: 3, MaxCap: 4, Factory: f})
+	f.setVersion(2)
+	a, _ := p.Get()
+	b, _ := p.Get()
+	v2, _ := p.Get() //新建, 版本 2
+	for _, conn := range []interface{}{a, b, v2} {
+		_ = p.Put(conn)
+	}
+
+	isV2 := func(conn interface{}) bool { return conn.(*versionConn).version == 2 }
+	got, err := p.GetMatching(isV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != v2 {
+		t.Fatalf("GetMatching = %+v, want the version 2 connection", got)
+	}
+	if p.Len() != 2 {
+		t.Fatalf("idle=%d, want the 2 version 1 connections kept", p.Len())
+	}
+
+	// 没有满足条件的空闲连接, 新建一条
+	dialed, err := p.GetMatching(isV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isV2(dialed) || dialed == v2 || p.Len() != 2 {
+		t.Fatalf("GetMatching = %+v with %d idle, want a newly dialed version 2 connection", dialed, p.Len())
+	}
+}
+
+func TestGetMatchingRespectsDegraded(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 1})
+	p.SetDegraded(true)
+	if _, err := p.GetMatching(func(interface{}) bool { return true }); err != ErrDegraded {
+		t.Fatalf("GetMatching = %v while degraded, want ErrDegraded", err)
+	}
+}
+
+func TestGetMatchingPredicateRunsOutsideLock(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 1, MaxCap: 2})
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.GetMatching(func(interface{}) bool {
+			return p.Stats().IdleConns < 0 //访问连接池, 在锁内调用会死锁; 永远不满足
+		})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrNoMatchingConn {
+			t.Fatalf("GetMatching = %v, want ErrNoMatchingConn", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetMatching deadlocked calling the predicate under the pool lock")
+	}
+	if f.Created() != 2 || f.Closed() != 1 || p.Len() != 1 {
+		t.Fatalf("created=%d closed=%d idle=%d, want the non-matching dial closed and the idle conn kept",
+			f.Created(), f.Closed(), p.Len())
+	}
+}
@@ -0,0 +1,33 @@
+package mypool
+
+import "errors"
+
+// GetForTenant 以租户身份取一个连接, 该租户借出数达到 MaxPerTenant 时返回 ErrTenantLimit.
+// 租户不能为空, 空字符串表示连接不属于任何租户
+func (c *channelPool) GetForTenant(tenant string) (interface{}, error) {
+	if tenant == "" {
+		return nil, errors.New("tenant is empty. rejecting")
+	}
+	// 先占住租户名额, 避免并发的同租户请求一起越过上限
+	c.mu.Lock()
+	if c.maxPerTenant > 0 && c.tenantInUse[tenant] >= c.maxPerTenant {
+		c.mu.Unlock()
+		return nil, ErrTenantLimit
+	}
+	c.tenantInUse[tenant]++
+	c.mu.Unlock()
+
+	conn, err := c.Get()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wrapConn, ok := c.active[conn]
+	if err != nil || !ok {
+		if c.tenantInUse[tenant]--; c.tenantInUse[tenant] <= 0 {
+			delete(c.tenantInUse, tenant)
+		}
+		return conn, err
+	}
+	wrapConn.tenant = tenant
+	return conn, nil
+}
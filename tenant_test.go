@@ -0,0 +1,35 @@
+package mypool
+
+import "testing"
+
+func TestGetForTenantLimit(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 3, MaxCap: 3, MaxPerTenant: 1})
+	a, err := p.GetForTenant("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetForTenant("a"); err != ErrTenantLimit {
+		t.Fatalf("second GetForTenant(a) = %v, want ErrTenantLimit", err)
+	}
+	if _, err := p.GetForTenant("b"); err != nil {
+		t.Fatalf("GetForTenant(b) = %v", err)
+	}
+	if err := p.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetForTenant("a"); err != nil {
+		t.Fatalf("GetForTenant(a) after Put = %v", err)
+	}
+}
+
+func TestGetForTenantRejectsEmpty(t *testing.T) {
+	p, _ := newTestPool(t, &PoolConfig{MaxIdle: 1, MaxCap: 2, MaxPerTenant: 1})
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetForTenant(""); err == nil {
+			t.Fatal("GetForTenant accepted an empty tenant")
+		}
+	}
+	if len(p.tenantInUse) != 0 {
+		t.Fatalf("tenant counts leaked: %v", p.tenantInUse)
+	}
+}
@@ -0,0 +1,41 @@
+package testutil_test
+
+import (
+	"fmt"
+	"time"
+
+	mypool "github.com/ZhangDahe/go_codes"
+	"github.com/ZhangDahe/go_codes/testutil"
+)
+
+// 空闲超过 IdleTimeout 的连接在 Get 时被丢弃, 换成新建的连接
+func ExampleNewMockFactory() {
+	f := testutil.NewMockFactory()
+	p, err := mypool.NewChannelPool(&mypool.PoolConfig{
+		InitialCap:  1,
+		MaxIdle:     1,
+		MaxCap:      2,
+		Factory:     f,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer p.Release()
+
+	time.Sleep(30 * time.Millisecond)
+	conn, err := p.Get()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for f.Closed() == 0 { //过期连接在后台关闭
+		time.Sleep(time.Millisecond)
+	}
+	fmt.Println("conn:", conn.(*testutil.MockConn).ID)
+	fmt.Println("created:", f.Created(), "closed:", f.Closed())
+	// Output:
+	// conn: 2
+	// created: 2 closed: 1
+}
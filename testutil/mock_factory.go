@@ -0,0 +1,134 @@
+// Package testutil 提供测试连接池用的内存假工厂
+package testutil
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMockPing 被标记为失效的连接 Ping 时返回的错误
+var ErrMockPing = errors.New("mock connection is broken")
+
+// MockConn 假连接, ID 从 1 开始顺序递增
+type MockConn struct {
+	ID int
+}
+
+// MockFactory 内存假工厂, 记录各方法的调用次数, 可注入错误
+type MockFactory struct {
+	mu       sync.Mutex
+	nextID   int
+	created  int
+	closed   int
+	pinged   int
+	dialErr  error
+	closeErr error
+	broken   map[int]bool
+
+	dialDelay, closeDelay time.Duration
+}
+
+// NewMockFactory 创建假工厂
+func NewMockFactory() *MockFactory {
+	return &MockFactory{broken: make(map[int]bool)}
+}
+
+// Factory 生成一个新的 *MockConn, 设置了 FailFactory 时返回注入的错误
+func (f *MockFactory) Factory() (interface{}, error) {
+	f.mu.Lock()
+	delay := f.dialDelay
+	f.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dialErr != nil {
+		return nil, f.dialErr
+	}
+	f.nextID++
+	f.created++
+	return &MockConn{ID: f.nextID}, nil
+}
+
+// Close 记录一次关闭, 设置了 FailClose 时返回注入的错误
+func (f *MockFactory) Close(conn interface{}) error {
+	f.mu.Lock()
+	delay := f.closeDelay
+	f.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed++
+	return f.closeErr
+}
+
+// Ping 记录一次检查, 被 Break 标记过的连接返回 ErrMockPing
+func (f *MockFactory) Ping(conn interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pinged++
+	if c, ok := conn.(*MockConn); ok && f.broken[c.ID] {
+		return ErrMockPing
+	}
+	return nil
+}
+
+// FailFactory 之后的 Factory 调用都返回 err, 传 nil 恢复正常
+func (f *MockFactory) FailFactory(err error) {
+	f.mu.Lock()
+	f.dialErr = err
+	f.mu.Unlock()
+}
+
+// FailClose 之后的 Close 调用都返回 err, 传 nil 恢复正常
+func (f *MockFactory) FailClose(err error) {
+	f.mu.Lock()
+	f.closeErr = err
+	f.mu.Unlock()
+}
+
+// SetDialDelay 之后每次 Factory 先等待 d 再返回, 模拟慢速拨号
+func (f *MockFactory) SetDialDelay(d time.Duration) {
+	f.mu.Lock()
+	f.dialDelay = d
+	f.mu.Unlock()
+}
+
+// SetCloseDelay 之后每次 Close 先等待 d 再返回, 模拟慢速关闭
+func (f *MockFactory) SetCloseDelay(d time.Duration) {
+	f.mu.Lock()
+	f.closeDelay = d
+	f.mu.Unlock()
+}
+
+// Break 标记连接失效, 之后对它的 Ping 都会失败
+func (f *MockFactory) Break(conn *MockConn) {
+	f.mu.Lock()
+	f.broken[conn.ID] = true
+	f.mu.Unlock()
+}
+
+// Created Factory 成功创建的连接数
+func (f *MockFactory) Created() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created
+}
+
+// Closed Close 的调用次数
+func (f *MockFactory) Closed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// Pinged Ping 的调用次数
+func (f *MockFactory) Pinged() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pinged
+}
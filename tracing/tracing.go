@@ -0,0 +1,66 @@
+// Package tracing 为连接池的 Get/Put 加上 OpenTelemetry span, 核心包不依赖 OTel
+package tracing
+
+import (
+	"context"
+	"time"
+
+	mypool "github.com/ZhangDahe/go_codes"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedPool 只拦截 Get/Put, 其余方法交给内嵌的 Pool.
+// 用 GetContext/PutContext 时 span 挂在调用方 ctx 的链路下, Get/Put 创建的是独立的根 span
+type TracedPool struct {
+	mypool.Pool
+	tracer trace.Tracer
+}
+
+// WrapPoolWithTracing 返回一个在 Get/Put 外层创建 span 的连接池
+func WrapPoolWithTracing(p mypool.Pool, tracer trace.Tracer) *TracedPool {
+	return &TracedPool{Pool: p, tracer: tracer}
+}
+
+// Get 在新的根 span 中获取连接
+func (t *TracedPool) Get() (interface{}, error) {
+	return t.GetContext(context.Background())
+}
+
+// GetContext 在 ctx 下的子 span 中获取连接, 记录等待时长和错误
+func (t *TracedPool) GetContext(ctx context.Context) (interface{}, error) {
+	_, span := t.tracer.Start(ctx, "mypool.Get")
+	defer span.End()
+
+	start := time.Now()
+	conn, err := t.Pool.Get()
+	span.SetAttributes(attribute.Int64("mypool.wait_us", time.Since(start).Microseconds()))
+	record(span, err)
+	return conn, err
+}
+
+// Put 在新的根 span 中放回连接
+func (t *TracedPool) Put(conn interface{}) error {
+	return t.PutContext(context.Background(), conn)
+}
+
+// PutContext 在 ctx 下的子 span 中放回连接, 记录错误
+func (t *TracedPool) PutContext(ctx context.Context, conn interface{}) error {
+	_, span := t.tracer.Start(ctx, "mypool.Put")
+	defer span.End()
+
+	err := t.Pool.Put(conn)
+	record(span, err)
+	return err
+}
+
+// record 把错误记到 span 上
+func record(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetStatus(codes.Ok, "")
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	mypool "github.com/ZhangDahe/go_codes"
+	"github.com/ZhangDahe/go_codes/testutil"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingSpan 记录 span 名字、状态和父 span
+type recordingSpan struct {
+	trace.Span
+	name   string
+	parent trace.SpanContext
+	status codes.Code
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+// recordingTracer 记录创建过的所有 span
+type recordingTracer struct {
+	noop.Tracer
+	spans []*recordingSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	_, span := noop.Tracer{}.Start(ctx, name)
+	s := &recordingSpan{Span: span, name: name, parent: trace.SpanContextFromContext(ctx)}
+	r.spans = append(r.spans, s)
+	return ctx, s
+}
+
+func TestTracedPoolRecordsSpans(t *testing.T) {
+	p, err := mypool.NewChannelPool(&mypool.PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: testutil.NewMockFactory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &recordingTracer{}
+	tp := WrapPoolWithTracing(p, rt)
+	conn, err := tp.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.Get(); err != mypool.ErrMaxActiveConnReached {
+		t.Fatalf("second Get = %v, want ErrMaxActiveConnReached", err)
+	}
+	if err := tp.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rt.spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(rt.spans))
+	}
+	if rt.spans[0].status != codes.Ok || rt.spans[1].status != codes.Error {
+		t.Fatalf("Get span status = %v, %v", rt.spans[0].status, rt.spans[1].status)
+	}
+	if rt.spans[2].name != "mypool.Put" {
+		t.Fatalf("last span = %q, want mypool.Put", rt.spans[2].name)
+	}
+}
+
+func TestTracedPoolJoinsCallerTrace(t *testing.T) {
+	p, err := mypool.NewChannelPool(&mypool.PoolConfig{MaxIdle: 1, MaxCap: 1, Factory: testutil.NewMockFactory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &recordingTracer{}
+	tp := WrapPoolWithTracing(p, rt)
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+
+	conn, err := tp.GetContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.PutContext(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range rt.spans {
+		if !s.parent.Equal(parent) {
+			t.Fatalf("span %q has parent %v, want the caller's span", s.name, s.parent)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package mypool
+
+import "errors"
+
+// TransferFrom 从 src 的空闲缓冲取出最多 n 条连接放入本连接池, 返回接手的条数.
+// 连接保留创建时刻、使用次数等元信息, 之后由本连接池的工厂检查和关闭, 调用方需保证两边工厂兼容.
+// 接手数量受本连接池的 MaxCap 和空闲缓冲余量限制
+func (c *channelPool) TransferFrom(src Pool, n int) (int, error) {
+	from, ok := src.(*channelPool)
+	if !ok {
+		return 0, errors.New("source pool does not support transfer")
+	}
+	if from == c || n <= 0 {
+		return 0, nil
+	}
+
+	// 先在本连接池预留名额和缓冲位置, 避免取出来却放不下
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil {
+		c.mu.Unlock()
+		return 0, ErrClosed
+	}
+	if room := c.maxActive - c.openingConns; n > room {
+		n = room
+	}
+	if room := c.maxIdle - len(c.conns); n > room {
+		n = room
+	}
+	if n <= 0 {
+		c.mu.Unlock()
+		return 0, nil
+	}
+	c.openingConns += n
+	factory, gen := c.factory, c.factoryGen
+	c.mu.Unlock()
+
+	from.mu.Lock()
+	moved := from.filterIdleLocked(func(*idleConn) bool {
+		if n > 0 {
+			n--
+			return false
+		}
+		return true
+	})
+	for range moved {
+		from.releaseSlotLocked()
+	}
+	from.mu.Unlock()
+
+	c.mu.Lock()
+	for ; n > 0; n-- { //源连接池空闲连接不够, 归还多预留的名额
+		c.releaseSlotLocked()
+	}
+	c.mu.Unlock()
+	for _, wrapConn := range moved {
+		wrapConn.factory, wrapConn.factoryGen = factory, gen
+		_ = c.requeueIdleConn(wrapConn) //保留原来的 lastUsed, 空闲时长接着算
+	}
+	return len(moved), nil
+}
@@ -0,0 +1,32 @@
+package mypool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferFromKeepsMetadata(t *testing.T) {
+	src, _ := newTestPool(t, &PoolConfig{InitialCap: 3, MaxIdle: 3, MaxCap: 3, IdleFromLastUse: true})
+	dst, _ := newTestPool(t, &PoolConfig{MaxIdle: 2, MaxCap: 5, IdleFromLastUse: true})
+	lastUsed := time.Now().Add(-time.Hour)
+	for _, wrapConn := range src.idleSnapshotLocked() {
+		wrapConn.lastUsed = lastUsed
+		wrapConn.useCount = 7
+	}
+
+	n, err := dst.TransferFrom(src, 5)
+	if err != nil || n != 2 {
+		t.Fatalf("TransferFrom = %d, %v; want 2 (limited by MaxIdle)", n, err)
+	}
+	if src.Len() != 1 || src.Stats().OpenConns != 1 {
+		t.Fatalf("source stats after transfer: %+v", src.Stats())
+	}
+	if dst.Len() != 2 || dst.Stats().OpenConns != 2 {
+		t.Fatalf("destination stats after transfer: %+v", dst.Stats())
+	}
+	for _, wrapConn := range dst.idleSnapshotLocked() {
+		if !wrapConn.lastUsed.Equal(lastUsed) || wrapConn.useCount != 7 {
+			t.Fatalf("metadata not carried over: lastUsed=%s useCount=%d", wrapConn.lastUsed, wrapConn.useCount)
+		}
+	}
+}
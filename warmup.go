@@ -0,0 +1,68 @@
+package mypool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// WarmUp 逐条新建最多 n 条空闲连接, 见 WarmUpConcurrent
+func (c *channelPool) WarmUp(n int) error {
+	return c.WarmUpConcurrent(n, 1)
+}
+
+// WarmUpConcurrent 新建最多 n 条空闲连接, 同时进行的拨号不超过 parallelism 个.
+// 实际条数受 MaxIdle 的剩余空位和 maxActive 的剩余名额限制, 名额在开始前一次占好.
+// 失败的拨号归还名额, 所有错误合并后返回
+func (c *channelPool) WarmUpConcurrent(n, parallelism int) error {
+	if n < 0 || parallelism < 1 {
+		return errors.New("invalid warm up settings")
+	}
+	c.mu.Lock()
+	if c.conns == nil || c.factory == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	n = min(n, c.maxIdle-len(c.conns), c.maxActive-c.openingConns)
+	if n <= 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	c.openingConns += n
+	factory, gen := c.factory, c.factoryGen
+	c.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c.waitDialTurn()
+			conn, err := c.create(factory)
+			if err != nil {
+				c.mu.Lock()
+				c.releaseSlotLocked()
+				c.mu.Unlock()
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			_ = c.putIdleConn(c.newIdleConn(conn, factory, gen))
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("warm up: %d of %d dials failed: %w", len(errs), n, errors.Join(errs...))
+	}
+	return nil
+}
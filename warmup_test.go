@@ -0,0 +1,47 @@
+package mypool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWarmUpConcurrent(t *testing.T) {
+	const dialDelay = 10 * time.Millisecond
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 50, MaxCap: 60})
+	f.SetDialDelay(dialDelay)
+	start := time.Now()
+	if err := p.WarmUpConcurrent(50, 5); err != nil {
+		t.Fatal(err)
+	}
+	// 逐条拨号至少需要 50*dialDelay, 5 路并行约为它的 1/5
+	if elapsed := time.Since(start); elapsed >= 25*dialDelay {
+		t.Fatalf("warm up took %s, want well under the serial %s", elapsed, 50*dialDelay)
+	}
+	if p.Len() != 50 || f.Created() != 50 || p.Stats().OpenConns != 50 {
+		t.Fatalf("idle=%d created=%d open=%d, want 50", p.Len(), f.Created(), p.Stats().OpenConns)
+	}
+}
+
+func TestWarmUpRespectsLimits(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{InitialCap: 1, MaxIdle: 3, MaxCap: 5})
+	if err := p.WarmUp(10); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 3 || f.Created() != 3 {
+		t.Fatalf("idle=%d created=%d, want MaxIdle 3", p.Len(), f.Created())
+	}
+}
+
+func TestWarmUpAggregatesErrors(t *testing.T) {
+	p, f := newTestPool(t, &PoolConfig{MaxIdle: 4, MaxCap: 4})
+	dialErr := errors.New("connection refused")
+	f.FailFactory(dialErr)
+	err := p.WarmUpConcurrent(4, 2)
+	if !errors.Is(err, dialErr) {
+		t.Fatalf("WarmUpConcurrent = %v, want it to wrap the dial error", err)
+	}
+	if s := p.Stats(); s.OpenConns != 0 {
+		t.Fatalf("open=%d after failed warm up, want slots returned", s.OpenConns)
+	}
+}